@@ -0,0 +1,78 @@
+// Package bridge lets a Tredd contract's buyer payment and seller collateral
+// live on different assets, with the exchange rate between them attested to
+// by a configurable set of oracle keys and committed into the contract at
+// propose-time. It mirrors the mainchain/sidechain peg-in/peg-out pattern:
+// value is locked against one asset and claimed, at a rate fixed in advance,
+// against another.
+package bridge
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"github.com/chain/txvm/crypto/ed25519"
+	"github.com/chain/txvm/errors"
+	"github.com/chain/txvm/protocol/bc"
+)
+
+// RateAttestation is an oracle's signed claim about the exchange rate between
+// two assets, valid until Expiry. Rate is expressed as the number of
+// CollateralAsset units equivalent to one PayAsset unit, scaled by 1e9, so
+// that it can be carried as an integer through the txvm contract.
+type RateAttestation struct {
+	PayAsset, CollateralAsset bc.Hash
+	Rate                      int64 // CollateralAsset per PayAsset, scaled by 1e9
+	Expiry                    time.Time
+
+	// OraclePubkey identifies which of the configured oracle keys produced Sig.
+	OraclePubkey ed25519.PublicKey
+	Sig          []byte
+}
+
+// Msg is the canonical byte string an oracle signs to produce Sig.
+func (a RateAttestation) Msg() []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%x:%x:%d:%d", a.PayAsset.Bytes(), a.CollateralAsset.Bytes(), a.Rate, bc.Millis(a.Expiry))
+	return buf.Bytes()
+}
+
+// Sign fills in a.OraclePubkey and a.Sig using oracle's private key.
+func (a *RateAttestation) Sign(oracle ed25519.PrivateKey) {
+	a.OraclePubkey = oracle.Public().(ed25519.PublicKey)
+	a.Sig = ed25519.Sign(oracle, a.Msg())
+}
+
+// OracleSet is the set of oracle public keys a Verify call will accept an
+// attestation's signature from.
+type OracleSet []ed25519.PublicKey
+
+// Contains reports whether pubkey is one of the configured oracle keys.
+func (s OracleSet) Contains(pubkey ed25519.PublicKey) bool {
+	for _, k := range s {
+		if bytes.Equal(k, pubkey) {
+			return true
+		}
+	}
+	return false
+}
+
+// Verify checks that att is signed by one of oracles, covers the given asset
+// pair, and has not expired as of now. It does not check the rate itself,
+// which is a matter for the caller (and, ultimately, the txvm contract) to
+// apply.
+func Verify(att RateAttestation, payAsset, collateralAsset bc.Hash, now time.Time, oracles OracleSet) error {
+	if !oracles.Contains(att.OraclePubkey) {
+		return errors.New("attestation not signed by a configured oracle")
+	}
+	if !ed25519.Verify(att.OraclePubkey, att.Msg(), att.Sig) {
+		return errors.New("invalid oracle signature")
+	}
+	if att.PayAsset != payAsset || att.CollateralAsset != collateralAsset {
+		return errors.New("attestation covers the wrong asset pair")
+	}
+	if !now.Before(att.Expiry) {
+		return errors.New("rate attestation has expired")
+	}
+	return nil
+}
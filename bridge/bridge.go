@@ -0,0 +1,347 @@
+package bridge
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"github.com/bobg/merkle"
+	"github.com/bobg/tredd"
+	"github.com/chain/txvm/crypto/ed25519"
+	"github.com/chain/txvm/errors"
+	"github.com/chain/txvm/protocol/bc"
+	"github.com/chain/txvm/protocol/txbuilder/standard"
+	"github.com/chain/txvm/protocol/txvm"
+	"github.com/chain/txvm/protocol/txvm/asm"
+	"github.com/chain/txvm/protocol/txvm/op"
+	"github.com/chain/txvm/protocol/txvm/txvmutil"
+)
+
+// contractSeed and contractProg identify the bridge variant of the Tredd
+// contract: the same propose/reveal/redeem state machine as the
+// single-asset contract, but parameterized by two (asset, amount) pairs
+// instead of one, plus the oracle set and the rate attestation checked at
+// reveal time. Like treddContractSeed/treddContractProg in the parent
+// package, their definitions live alongside the contract's txvm asm, which
+// is out of scope for this package; they are deliberately left undeclared
+// rather than given zero values, since a zero-value contractSeed/
+// contractProg would let every function in this file build and "run"
+// against a contract instance that doesn't exist, silently skipping the
+// oracle-signature check Verify is supposed to enforce on chain.
+
+// ProposePayment constructs a partial transaction in which the buyer commits
+// payAmount of payAsset to a bridge Tredd contract, to be redeemed by a
+// seller posting collateralAmount of collateralAsset. att must attest to the
+// exchange rate between the two assets, signed by one of oracles; the
+// contract asm re-checks att's signature at reveal time against the same
+// oracle set, so a stale or forged rate cannot be used to redeem.
+func ProposePayment(
+	ctx context.Context,
+	buyer ed25519.PublicKey,
+	payAmount int64,
+	payAsset bc.Hash,
+	collateralAmount int64,
+	collateralAsset bc.Hash,
+	clearRoot, cipherRoot [32]byte,
+	att RateAttestation,
+	oracles OracleSet,
+	now, revealDeadline, refundDeadline time.Time,
+	reserver tredd.Reserver,
+	opts *tredd.ReserveOpts,
+	signer tredd.Signer,
+) ([]byte, error) {
+	if err := Verify(att, payAsset, collateralAsset, now, oracles); err != nil {
+		return nil, errors.Wrap(err, "checking rate attestation")
+	}
+
+	reservation, err := reserver.Reserve(ctx, payAmount, payAsset, now, revealDeadline, opts)
+	if err != nil {
+		return nil, errors.Wrap(err, "reserving utxos")
+	}
+	utxos, err := reservation.UTXOs(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "querying utxos from reservation")
+	}
+	change, err := reservation.Change(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "querying change amount from reservation")
+	}
+
+	treddLogPos := 2 * int64(len(utxos))
+	if change > 0 {
+		treddLogPos += 3
+	}
+
+	buf := new(bytes.Buffer)
+	fmt.Fprint(buf, "[")
+
+	fmt.Fprintf(buf, "%d peeklog untuple\n", treddLogPos)
+	fmt.Fprintf(buf, "4 eq verify\n")
+	fmt.Fprintf(buf, "3 roll 'R' eq verify\n")
+	fmt.Fprintf(buf, "2 roll x'%x' eq verify\n", contractSeed[:])
+	fmt.Fprintf(buf, "%d eq verify\n", bc.Millis(revealDeadline))
+	fmt.Fprintf(buf, "0 eq verify\n")
+
+	fmt.Fprintf(buf, "%d peeklog untuple drop\n", treddLogPos+1)
+	fmt.Fprintf(buf, "%d eq verify\n", bc.Millis(refundDeadline))
+	fmt.Fprintf(buf, "drop drop\n")
+
+	fmt.Fprintf(buf, "%d peeklog untuple drop\n", treddLogPos+2)
+	fmt.Fprintf(buf, "x'%x' eq verify\n", buyer)
+	fmt.Fprintf(buf, "drop drop\n")
+
+	fmt.Fprintf(buf, "%d peeklog untuple drop\n", treddLogPos+3)
+	fmt.Fprintf(buf, "x'%x' eq verify\n", cipherRoot[:])
+	fmt.Fprintf(buf, "drop drop\n")
+
+	fmt.Fprintf(buf, "%d peeklog untuple drop\n", treddLogPos+4)
+	fmt.Fprintf(buf, "x'%x' eq verify\n", clearRoot[:])
+	fmt.Fprintf(buf, "drop drop\n")
+
+	fmt.Fprintf(buf, "%d peeklog untuple drop\n", treddLogPos+5)
+	fmt.Fprintf(buf, "%d eq verify\n", payAmount)
+	fmt.Fprintf(buf, "drop drop\n")
+
+	fmt.Fprintf(buf, "%d peeklog untuple drop\n", treddLogPos+6)
+	fmt.Fprintf(buf, "x'%x' eq verify\n", payAsset.Bytes())
+	fmt.Fprintf(buf, "drop drop\n")
+
+	fmt.Fprintf(buf, "%d peeklog untuple drop\n", treddLogPos+7)
+	fmt.Fprintf(buf, "%d eq verify\n", collateralAmount)
+	fmt.Fprintf(buf, "drop drop\n")
+
+	fmt.Fprintf(buf, "%d peeklog untuple drop\n", treddLogPos+8)
+	fmt.Fprintf(buf, "x'%x' eq verify\n", collateralAsset.Bytes())
+	fmt.Fprintf(buf, "drop drop\n")
+
+	fmt.Fprintf(buf, "%d peeklog untuple drop\n", treddLogPos+9)
+	fmt.Fprintf(buf, "x'%x' eq verify\n", att.Msg())
+	fmt.Fprintf(buf, "drop drop\n")
+
+	fmt.Fprint(buf, "] yield")
+
+	sigprog, err := asm.Assemble(buf.String())
+	if err != nil {
+		return nil, errors.Wrap(err, "assembling signature program")
+	}
+
+	anchoredSigprog := make([]byte, 32+len(sigprog))
+	copy(anchoredSigprog, sigprog)
+
+	b := new(txvmutil.Builder)
+	for i, utxo := range utxos {
+		b.PushdataBytes([]byte{}).Op(op.Put)
+		standard.SpendMultisig(b, 1, []ed25519.PublicKey{buyer}, utxo.Amount(), utxo.AssetID(), utxo.Anchor(), standard.PayToMultisigSeed2[:])
+		b.Op(op.Get)
+
+		copy(anchoredSigprog[len(sigprog):], utxo.Anchor())
+		sig, err := signer(anchoredSigprog)
+		if err != nil {
+			return nil, errors.Wrap(err, "signing input")
+		}
+		b.PushdataBytes(sig).Op(op.Put)
+		b.PushdataBytes(sigprog).Op(op.Put)
+		b.Op(op.Call)
+
+		b.Op(op.Get).Op(op.Get).PushdataInt64(1).Op(op.Roll).Op(op.Put)
+
+		if i > 0 {
+			b.Op(op.Merge)
+		}
+	}
+	if change > 0 {
+		b.PushdataInt64(change).Op(op.Split)
+
+		b.PushdataBytes(nil).Op(op.Put)
+		b.PushdataBytes(nil).Op(op.Put)
+		b.Op(op.Put)
+		b.PushdataBytes(buyer).PushdataInt64(1).Op(op.Tuple).Op(op.Put)
+		b.PushdataInt64(1).Op(op.Put)
+		b.PushdataBytes(standard.PayToMultisigProg2).Op(op.Contract).Op(op.Call)
+	}
+
+	b.PushdataBytes(contractProg).Op(op.Contract)
+	b.PushdataInt64(1).Op(op.Roll)
+
+	b.Op(op.Put) // payment, already on the contract stack
+	b.PushdataBytes(att.Msg()).Op(op.Put)
+	b.PushdataBytes(collateralAsset.Bytes()).Op(op.Put)
+	b.PushdataInt64(collateralAmount).Op(op.Put)
+	b.PushdataBytes(payAsset.Bytes()).Op(op.Put)
+	b.PushdataInt64(payAmount).Op(op.Put)
+	b.PushdataBytes(clearRoot[:]).Op(op.Put)
+	b.PushdataBytes(cipherRoot[:]).Op(op.Put)
+	b.PushdataBytes(buyer).Op(op.Put)
+	b.PushdataInt64(int64(bc.Millis(refundDeadline))).Op(op.Put) // TODO: range check
+	b.PushdataInt64(int64(bc.Millis(revealDeadline))).Op(op.Put) // TODO: range check
+
+	b.Op(op.Call)
+
+	b.Op(op.Get)
+
+	for i := 0; i < len(utxos); i++ {
+		b.Op(op.Get).Op(op.Call)
+	}
+
+	return b.Build(), nil
+}
+
+// ParseResult holds the values parsed from the log of a bridge Tredd
+// contract invocation. It extends tredd.ParseResult with the collateral
+// asset's own amount/asset-ID/anchor and the rate attestation message
+// that was committed at propose-time. The embedded Anchor2 (filled in by
+// the reveal-phase scan below, same as tredd.ParseResult's own) holds the
+// pay-side anchor; CollateralAnchor2 holds the collateral side's, since -
+// unlike tredd.Redeem - bridge.Redeem needs both to rehydrate a contract
+// holding two distinct-asset Values.
+type ParseResult struct {
+	tredd.ParseResult
+	CollateralAmount  int64
+	CollateralAsset   []byte
+	CollateralAnchor2 []byte
+	AttestationMsg    []byte
+}
+
+// ParseLog parses the log of a (possibly partial) bridge contract
+// transaction program, analogous to tredd.ParseLog.
+func ParseLog(prog []byte) *ParseResult {
+	vm, err := txvm.Validate(prog, 3, 1<<62, txvm.StopAfterFinalize)
+	if vm == nil || err != nil {
+		return nil
+	}
+	var res *ParseResult
+	for i, item := range vm.Log {
+		if len(item) != 4 {
+			continue
+		}
+		code, ok := item[0].(txvm.Bytes)
+		if !ok || !bytes.Equal(code, []byte{'R'}) {
+			continue
+		}
+		if !bytes.Equal(item[1].(txvm.Bytes), contractSeed[:]) {
+			continue
+		}
+		res = &ParseResult{}
+		res.RevealDeadline = bc.FromMillis(uint64(item[3].(txvm.Int)))
+		res.RefundDeadline = bc.FromMillis(uint64(vm.Log[i+1][2].(txvm.Int)))
+		res.Buyer = ed25519.PublicKey(vm.Log[i+2][2].(txvm.Bytes))
+		res.CipherRoot = vm.Log[i+3][2].(txvm.Bytes)
+		res.ClearRoot = vm.Log[i+4][2].(txvm.Bytes)
+		res.Amount = int64(vm.Log[i+5][2].(txvm.Int))
+		res.AssetID = vm.Log[i+6][2].(txvm.Bytes)
+		res.CollateralAmount = int64(vm.Log[i+7][2].(txvm.Int))
+		res.CollateralAsset = vm.Log[i+8][2].(txvm.Bytes)
+		res.AttestationMsg = vm.Log[i+9][2].(txvm.Bytes)
+
+		// The propose-phase 'R' entry above only commits to the terms;
+		// the seller's reveal (a later 'L' entry, once one exists) is
+		// what actually carries the anchors of the pay and collateral
+		// Values as merged/posted by that reveal, plus the key and the
+		// seller's identity - mirroring tredd.ParseLog's own second loop.
+		for j := i + 10; j < len(vm.Log); j++ {
+			item := vm.Log[j]
+			if len(item) != 3 {
+				continue
+			}
+			code, ok := item[0].(txvm.Bytes)
+			if !ok || !bytes.Equal(code, []byte{'L'}) {
+				continue
+			}
+			if !bytes.Equal(item[1].(txvm.Bytes), contractSeed[:]) {
+				continue
+			}
+			res.Anchor2 = vm.Log[j][2].(txvm.Bytes)
+			res.CollateralAnchor2 = vm.Log[j+1][2].(txvm.Bytes)
+			res.Key = vm.Log[j+2][2].(txvm.Bytes)
+			res.Seller = ed25519.PublicKey(vm.Log[j+3][2].(txvm.Bytes))
+			res.OutputID = vm.Log[j+4][2].(txvm.Bytes)
+			break
+		}
+		break
+	}
+	return res
+}
+
+// Redeem holds the values needed to redeem a bridge Tredd contract, carrying
+// both asset/amount pairs in place of tredd.Redeem's single pair. Unlike
+// tredd.Redeem's single Anchor2, pay and collateral stay two separate
+// Value tuples all the way through to redemption, since a single txvm
+// Value can't carry two different asset IDs and so the two can never be
+// merged the way same-asset payment and collateral are in tx.go.
+type Redeem struct {
+	RefundDeadline        time.Time
+	Buyer, Seller         ed25519.PublicKey
+	PayAmount             int64
+	PayAsset              bc.Hash
+	CollateralAmount      int64
+	CollateralAsset       bc.Hash
+	PayAnchor2            [32]byte
+	CollateralAnchor2     [32]byte
+	CipherRoot, ClearRoot [32]byte
+	Key                   [32]byte
+	AttestationMsg        []byte
+}
+
+// redeem builds the input tuple common to ClaimPayment and ClaimRefund,
+// rehydrating a bridge Tredd contract instance from the utxo state
+// identified by r: the same shape as tx.go's redeem, but with two {'V',
+// ...} tuples (pay and collateral, each its own asset and anchor) in
+// place of tx.go's single merged one.
+func redeem(r *Redeem) *bytes.Buffer {
+	buf := new(bytes.Buffer)
+	fmt.Fprintf(
+		buf,
+		"{'C', x'%x', x'%x', {'Z', %d}, {'S', x'%x'}, {'V', %d, x'%x', x'%x'}, {'V', %d, x'%x', x'%x'}, {'S', x'%x'}, {'S', x'%x'}, {'S', x'%x'}, {'S', x'%x'}, x'%x'} input\n",
+		contractSeed,
+		contractProg,
+		bc.Millis(r.RefundDeadline),
+		r.Buyer,
+		r.PayAmount, r.PayAsset.Bytes(), r.PayAnchor2[:],
+		r.CollateralAmount, r.CollateralAsset.Bytes(), r.CollateralAnchor2[:],
+		r.CipherRoot[:],
+		r.ClearRoot[:],
+		r.Key[:],
+		r.Seller,
+		r.AttestationMsg,
+	)
+	return buf
+}
+
+// ClaimPayment constructs a seller-claims-payment transaction for a bridge
+// Tredd contract, rehydrating it from utxo state identified by r.
+func ClaimPayment(r *Redeem) ([]byte, error) {
+	buf := redeem(r)
+	fmt.Fprintln(buf, "0 put call")
+	fmt.Fprintln(buf, "get finalize")
+	return asm.Assemble(buf.String())
+}
+
+// ClaimRefund constructs a buyer-claims-refund transaction for a bridge
+// Tredd contract, mirroring tx.go's ClaimRefund: a negative index means
+// the seller never revealed a key at all, so the claim rests on
+// refundDeadline alone with no per-chunk proof (cipherChunk, clearHash,
+// cipherProof, and clearProof are ignored).
+func ClaimRefund(r *Redeem, index int64, cipherChunk []byte, clearHash []byte, cipherProof, clearProof merkle.Proof) ([]byte, error) {
+	buf := redeem(r)
+	if index < 0 {
+		fmt.Fprintln(buf, "1 put call")
+		fmt.Fprintln(buf, "get finalize")
+		return asm.Assemble(buf.String())
+	}
+
+	var prefix [binary.MaxVarintLen64]byte
+	m := binary.PutUvarint(prefix[:], uint64(index))
+
+	tredd.RenderProof(buf, cipherProof)
+	fmt.Fprintln(buf, "put")
+	tredd.RenderProof(buf, clearProof)
+	fmt.Fprintln(buf, "put")
+	fmt.Fprintf(buf, "x'%x' put\n", clearHash)
+	fmt.Fprintf(buf, "x'%x' put\n", cipherChunk)
+	fmt.Fprintf(buf, "x'%x' put\n", prefix[:m])
+	fmt.Fprintln(buf, "1 put call")
+	fmt.Fprintln(buf, "get finalize")
+	return asm.Assemble(buf.String())
+}
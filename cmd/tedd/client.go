@@ -1,32 +1,31 @@
 package main
 
 import (
-	"bytes"
 	"context"
 	"crypto/sha256"
-	"encoding/binary"
 	"encoding/hex"
 	"flag"
 	"fmt"
 	"io"
-	"log"
-	"math"
-	"net/http"
-	"net/url"
 	"os"
 	"path"
-	"strconv"
 	"time"
 
-	"github.com/bobg/merkle"
 	"github.com/bobg/tedd"
+	"github.com/bobg/tredd"
+	"github.com/bobg/tredd/settle"
+	"github.com/bobg/tredd/transport"
 	"github.com/chain/txvm/crypto/ed25519"
 	"github.com/chain/txvm/protocol/bc"
-	"github.com/chain/txvm/protocol/txvm"
 	"github.com/coreos/bbolt"
 )
 
-func get(args []string) {
+// get runs one client-side transfer to completion (or until it's left
+// waiting on a key or refund deadline, in which case `tedd resume` picks
+// it back up). It returns an error instead of calling log.Fatal so that a
+// daemon driving many concurrent transfers can fail one without taking
+// down the others.
+func get(args []string) error {
 	ctx := context.Background()
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
@@ -41,31 +40,32 @@ func get(args []string) {
 		refundDeadlineDurStr = flag.String("refund", "", "time from reveal deadline until refund deadline")
 		dbFile               = fs.String("db", "", "file containing client-state db")
 		prvFile              = fs.String("prv", "", "file containing client private key")
-		serverURL            = fs.String("server", "", "base URL of tedd server")
+		serverAddr           = fs.String("server", "", "tedd server address: an http(s):// base URL, or a /p2p/... multiaddr")
 		bcURL                = fs.String("bcurl", "", "base URL of blockchain server")
 		dir                  = fs.String("dir", "", "root dir for file transfers")
+		changeDust           = fs.Int64("changedust", -1, "if >= 0, try to reserve utxos summing to the payment amount within this much leftover, to avoid a change output (see tredd.Changeless)")
 	)
 
 	err := fs.Parse(args)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
-	var (
-		requestURL        = *serverURL + "/request"
-		proposePaymentURL = *serverURL + "/propose-payment"
-	)
+	tport, err := transport.Pick(ctx, *serverAddr)
+	if err != nil {
+		return err
+	}
 
 	f, err := os.Open(*prvFile)
 	if err != nil {
-		log.Fatalf("opening prv file %s: %s", *prvFile, err)
+		return fmt.Errorf("opening prv file %s: %w", *prvFile, err)
 	}
 	defer f.Close()
 
 	var prvbuf [ed25519.PrivateKeySize]byte
 	_, err = io.ReadFull(f, prvbuf[:])
 	if err != nil {
-		log.Fatalf("reading prv file %s: %s", *prvFile, err)
+		return fmt.Errorf("reading prv file %s: %w", *prvFile, err)
 	}
 	f.Close()
 
@@ -75,77 +75,143 @@ func get(args []string) {
 	var clearRoot [32]byte
 	_, err = hex.Decode(clearRoot[:], []byte(*clearRootHex))
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
+	log := requestLogger(buyer, clearRoot)
+
 	assetIDBytes, err := hex.DecodeString(*assetIDHex)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 	assetID := bc.HashFromBytes(assetIDBytes)
 
 	revealDeadlineDur, err := time.ParseDuration(*revealDeadlineDurStr)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 	revealDeadline := time.Now().Add(revealDeadlineDur)
 
 	refundDeadlineDur, err := time.ParseDuration(*refundDeadlineDurStr)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 	refundDeadline := revealDeadline.Add(refundDeadlineDur)
 
 	db, err := bbolt.Open(*dbFile, 0600, nil)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 	defer db.Close()
 
-	log.Print("launching blockchain observer")
+	log.Info("launching blockchain observer", "stage", "observe")
 	o := newObserver(db, buyer, *bcURL+"/get")
 	go o.run(ctx)
 
-	var vals url.Values
-	vals.Add("clearroot", *clearRootHex)
-	vals.Add("amount", strconv.FormatInt(*amount, 10))
-	vals.Add("assetid", *assetIDHex)
-	vals.Add("revealdeadline", strconv.FormatInt(int64(bc.Millis(revealDeadline)), 10)) // xxx range check
-	vals.Add("refunddeadline", strconv.FormatInt(int64(bc.Millis(refundDeadline)), 10)) // xxx range check
-
-	log.Print("requesting content")
-	resp, err := http.PostForm(requestURL, vals)
+	log.Info("requesting content", "stage", "request")
+	transferID, err := tport.Request(ctx, *serverAddr, buyer, clearRoot, *amount, assetID.Bytes(), revealDeadline, refundDeadline, transport.Range{})
 	if err != nil {
-		log.Fatal(err)
+		return fmt.Errorf("requesting content: %w", err)
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode/100 != 2 {
-		log.Fatalf("status code %d from initial HTTP request", resp.StatusCode)
+	cipherStream, err := tport.StreamCipher(ctx, transferID)
+	if err != nil {
+		return fmt.Errorf("opening cipher stream: %w", err)
 	}
+	defer cipherStream.Close()
 
 	var (
-		transferID       = resp.Header.Get("X-Tedd-Transfer-Id")
 		clearHashesFile  = path.Join(*dir, fmt.Sprintf("hashes-%s", transferID))
 		cipherChunksFile = path.Join(*dir, fmt.Sprintf("chunks-%s", transferID))
 	)
 
+	outFileName := path.Join(*dir, hex.EncodeToString(clearRoot[:]))
+
+	rec := &transferRecord{
+		TransferID:     transferID,
+		ClearRoot:      clearRoot,
+		Amount:         *amount,
+		AssetID:        assetID,
+		RevealDeadline: revealDeadline,
+		RefundDeadline: refundDeadline,
+		Buyer:          buyer,
+		HashesFile:     clearHashesFile,
+		ChunksFile:     cipherChunksFile,
+		OutFile:        outFileName,
+		State:          StateRequested,
+	}
+	if err := putTransfer(db, rec); err != nil {
+		return fmt.Errorf("recording transfer state: %w", err)
+	}
+
+	log = transferLogger(rec)
+
 	clearHashes, err := newFileChunkStore(clearHashesFile, 32)
 	if err != nil {
-		log.Fatalf("creating hash chunk store: %s", err)
+		return fmt.Errorf("creating hash chunk store: %w", err)
 	}
-	defer os.Remove(clearHashesFile) // TODO: keep this around if needed to recover from errors
+	// The chunk-store files are kept around (instead of being removed here
+	// unconditionally) so that a crash between proposing payment and
+	// receiving the key doesn't strand committed funds with no way to resume
+	// decryption or claim a refund; finishTransfer removes them once it has
+	// actually completed one or the other. See `tedd resume`.
 
 	cipherChunks, err := newFileChunkStore(cipherChunksFile, tedd.ChunkSize)
 	if err != nil {
-		log.Fatalf("creating cipher chunk store: %s", err)
+		return fmt.Errorf("creating cipher chunk store: %w", err)
 	}
-	defer os.Remove(cipherChunksFile) // TODO: keep this around if needed to recover from errors
 
-	log.Print("storing cipher chunks and checking clear hashes")
-	cipherRoot, err := tedd.Get(resp.Body, clearRoot, clearHashes, cipherChunks)
+	// This can't use tredd.GetAndDecrypt to fold verification and
+	// decryption into one pass: the seller's key isn't revealed until
+	// after payment is proposed, which in turn can't happen until the
+	// cipher stream below has already been verified against clearRoot.
+	// See GetAndDecrypt's doc comment.
+	log.Info("storing cipher chunks and checking clear hashes", "stage", "get")
+	cipherRoot, err := tedd.Get(cipherStream, clearRoot, clearHashes, cipherChunks)
 	if err != nil {
-		log.Fatal(err)
+		return err
+	}
+
+	rec.ClearProofFile = path.Join(*dir, fmt.Sprintf("clearproof-%s", transferID))
+	rec.CipherProofFile = path.Join(*dir, fmt.Sprintf("cipherproof-%s", transferID))
+
+	// Building the refund-proof caches here, in the same pass that just
+	// stored every chunk, means a later refund (see finishTransfer) only
+	// has to read the O(log n) siblings on one leaf's path back out of
+	// these files, instead of re-reading every chunk from disk again.
+	clearCache, err := tredd.NewProofCache(rec.ClearProofFile, sha256.New)
+	if err != nil {
+		return fmt.Errorf("creating clear-hash proof cache: %w", err)
+	}
+	cipherCache, err := tredd.NewProofCache(rec.CipherProofFile, sha256.New)
+	if err != nil {
+		return fmt.Errorf("creating cipher-chunk proof cache: %w", err)
+	}
+	nchunks, err := cipherChunks.Len()
+	if err != nil {
+		return fmt.Errorf("counting cipher chunks: %w", err)
+	}
+	for index := uint64(0); index < uint64(nchunks); index++ {
+		h, err := clearHashes.Get(index)
+		if err != nil {
+			return fmt.Errorf("reading clear hash %d: %w", index, err)
+		}
+		if err := clearCache.Add(h); err != nil {
+			return fmt.Errorf("caching clear hash %d: %w", index, err)
+		}
+		c, err := cipherChunks.Get(index)
+		if err != nil {
+			return fmt.Errorf("reading cipher chunk %d: %w", index, err)
+		}
+		if err := cipherCache.Add(c); err != nil {
+			return fmt.Errorf("caching cipher chunk %d: %w", index, err)
+		}
+	}
+	if err := clearCache.Close(); err != nil {
+		return fmt.Errorf("closing clear-hash proof cache: %w", err)
+	}
+	if err := cipherCache.Close(); err != nil {
+		return fmt.Errorf("closing cipher-chunk proof cache: %w", err)
 	}
 
 	signer := func(msg []byte) ([]byte, error) {
@@ -155,164 +221,67 @@ func get(args []string) {
 	var cipherRootBuf [32]byte
 	copy(cipherRootBuf[:], cipherRoot)
 
-	now, err := o.now()
-	if err != nil {
-		log.Fatal(err)
+	var reserveOpts *tredd.ReserveOpts
+	if *changeDust >= 0 {
+		reserveOpts = &tredd.ReserveOpts{Strategy: tredd.Changeless{DustTolerance: *changeDust}}
 	}
 
-	prog, err := tedd.ProposePayment(ctx, buyer, *amount, assetID, clearRoot, cipherRootBuf, now, revealDeadline, refundDeadline, o.r, signer)
+	// settlement is the only thing below this point that knows it's
+	// talking to chain/txvm; a different Settlement implementation (see
+	// the settle package) would let this same get() run against any
+	// chain that can express hash-locked escrow.
+	settlement := settle.NewTxvm(o.r, reserveOpts, signer, nil, *bcURL)
+
+	anchor1, prog, err := settlement.ProposePayment(ctx, buyer, *amount, assetID.Bytes(), clearRoot, cipherRootBuf, revealDeadline, refundDeadline)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
-	parsed := tedd.ParseLog(prog)
-	if parsed == nil {
-		log.Fatal("cannot parse log of proposed payment transaction")
+	rec.State = StatePaymentProposed
+	rec.CipherRoot = cipherRootBuf
+	rec.Anchor1 = anchor1
+	if err := putTransfer(db, rec); err != nil {
+		return fmt.Errorf("recording transfer state: %w", err)
 	}
-	anchor1 := parsed.Anchor1
-
-	submit := submitter(*bcURL + "/submit")
 
 	o.setcb(func(tx *bc.Tx) {
 		defer cancel()
 
-		parsed := tedd.ParseLog(tx.Program)
-		if parsed == nil {
-			return
-		}
-		if !bytes.Equal(parsed.Anchor1, anchor1) {
+		accepted := settlement.ParseAcceptance(tx.Program, anchor1)
+		if accepted == nil {
 			return
 		}
 
-		log.Printf("payment proposal accepted, key is %x; now decrypting", parsed.Key)
-
-		// Payment has been accepted.
-		var key [32]byte
-		copy(key[:], parsed.Key)
+		log.Info("payment proposal accepted; now decrypting", "stage", "reveal", "key", hex.EncodeToString(accepted.Key))
 
-		outFileName := path.Join(*dir, hex.EncodeToString(clearRoot[:]))
-		out, err := os.Create(outFileName)
-		if err != nil {
-			log.Fatalf("creating %s: %s", outFileName, err) // TODO: more graceful/recoverable handling
-		}
-		defer out.Close()
-
-		err = tedd.Decrypt(out, clearHashes, cipherChunks, key)
-		if bchErr, ok := err.(tedd.BadClearHashError); ok {
-			log.Printf("decryption failed on chunk %d; now claiming refund", bchErr.Index)
-
-			redeem := &tedd.Redeem{
-				RefundDeadline: refundDeadline,
-				Buyer:          buyer,
-				Seller:         parsed.Seller,
-				Amount:         2 * *amount,
-				AssetID:        assetID,
-				ClearRoot:      clearRoot,
-				Key:            key,
-			}
-			copy(redeem.CipherRoot[:], cipherRoot)
-			copy(redeem.Anchor2[:], parsed.Anchor2)
-
-			var (
-				refHash        [32 + binary.MaxVarintLen64]byte
-				refCipherChunk [tedd.ChunkSize + binary.MaxVarintLen64]byte
-			)
-			m := binary.PutUvarint(refHash[:], bchErr.Index)
-			binary.PutUvarint(refCipherChunk[:], bchErr.Index)
-
-			g, err := clearHashes.Get(bchErr.Index)
-			if err != nil {
-				log.Fatalf("getting hash %d from %s: %s", bchErr.Index, clearHashes.filename, err)
-			}
-			copy(refHash[m:], g)
-
-			g, err = cipherChunks.Get(bchErr.Index)
-			if err != nil {
-				log.Fatalf("getting cipher chunk %d from %s: %s", bchErr.Index, cipherChunks.filename, err)
-			}
-			copy(refCipherChunk[m:], g)
-
-			var (
-				clearTree  = merkle.NewProofTree(sha256.New(), refHash[:m+32])
-				cipherTree = merkle.NewProofTree(sha256.New(), refCipherChunk[:m+len(g)])
-				hasher     = sha256.New()
-			)
-			nchunks, err := cipherChunks.Len()
-			if err != nil {
-				log.Fatalf("getting length of cipher chunk store %s: %s", cipherChunks.filename, err)
-			}
-			for index := uint64(0); index < uint64(nchunks); index++ {
-				var chunk [tedd.ChunkSize + binary.MaxVarintLen64]byte
-				m := binary.PutUvarint(chunk[:], index)
-				ci, err := cipherChunks.Get(index)
-				if err != nil {
-					log.Fatalf("getting cipher chunk %d from %s: %s", bchErr.Index, cipherChunks.filename, err)
-				}
-				copy(chunk[m:], ci)
-				n := len(ci)
-
-				var h [32 + binary.MaxVarintLen64]byte
-				binary.PutUvarint(h[:], index)
-				merkle.LeafHash(hasher, h[:m], chunk[:m+n])
-
-				clearTree.Add(h[:m+32])
-				cipherTree.Add(chunk[:m+n])
-			}
-
-			var (
-				clearProof  = clearTree.Proof()
-				cipherProof = cipherTree.Proof()
-			)
-
-			prog, err := tedd.ClaimRefund(redeem, int64(bchErr.Index), refCipherChunk[m:m+len(g)], refHash[m:m+32], cipherProof, clearProof) // xxx range check
-			if err != nil {
-				log.Fatalf("constructing refund-claiming transaction: %s", err)
-			}
-
-			vm, err := txvm.Validate(prog, 3, math.MaxInt64)
-			if err != nil {
-				log.Fatalf("calculating runlimit for refund-claiming transaction: %s", err)
-			}
-
-			err = submit(prog, 3, math.MaxInt64-vm.Runlimit())
-			if err != nil {
-				// TODO: retry
-				log.Fatalf("submitting refund-claiming transaction: %s", err)
-			}
+		rec.Seller = accepted.Seller
+		rec.Anchor2 = accepted.Anchor2
+		rec.Key = accepted.Key
+		rec.State = StateKeyRevealed
+		if err := putTransfer(db, rec); err != nil {
+			log.Error("recording transfer state", "stage", "reveal", "err", err)
 			return
 		}
-		if err != nil {
-			log.Fatalf("decrypting content: %s", err)
+
+		if err := finishTransfer(rec, db, settlement); err != nil {
+			log.Error("finishing transfer", "stage", "finish", "err", err)
 		}
-		log.Print("complete")
 	})
 	o.enqueue(revealDeadline, func() {
-		log.Print("reveal deadline has arrived, transfer invalid")
+		log.Info("reveal deadline has arrived, transfer invalid", "stage", "reveal")
+		if err := claimTimeoutRefund(rec, db, settlement); err != nil {
+			log.Error("claiming timeout refund", "stage", "reveal", "err", err)
+		}
 		cancel()
 	})
 
-	log.Print("proposing payment")
-	req, err := http.NewRequest("POST", proposePaymentURL, bytes.NewReader(prog))
-	if err != nil {
-		log.Fatalf("constructing payment proposal: %s", err)
-	}
-	req = req.WithContext(ctx)
-
-	req.Header.Set("X-Tedd-Transfer-Id", transferID)
-
-	var client http.Client
-	resp, err = client.Do(req) // from this point, funds are committed - perhaps even in case of error
-	if err != nil {
-		log.Printf("sending payment proposal: %s", err)
-		log.Print("WARNING: funds may be committed; awaiting outcome")
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusNoContent {
-		log.Printf("sending payment proposal: unexpected status %d", resp.StatusCode)
-		log.Print("WARNING: funds may be committed; awaiting outcome")
+	log.Info("proposing payment", "stage", "propose-payment")
+	if err := tport.SendPayment(ctx, transferID, prog); err != nil { // from this point, funds are committed - perhaps even in case of error
+		log.Warn("sending payment proposal", "stage", "propose-payment", "err", err)
+		log.Warn("funds may be committed; awaiting outcome", "stage", "propose-payment")
 	}
 
-	log.Print("awaiting key or reveal deadline")
+	log.Info("awaiting key or reveal deadline", "stage", "await")
 	<-ctx.Done()
-}
\ No newline at end of file
+	return nil
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"os"
+
+	"github.com/bobg/tedd"
+	"github.com/bobg/tredd"
+	"github.com/bobg/tredd/settle"
+	"github.com/coreos/bbolt"
+)
+
+// finishTransfer decrypts the content for rec, or claims a refund if
+// decryption fails, and records the outcome in db. It is the continuation
+// that both a normal `get` and `tedd resume` run once a transfer's key has
+// been revealed (rec.State == StateKeyRevealed), so that a process crash
+// between reveal and completion doesn't strand committed funds.
+func finishTransfer(rec *transferRecord, db *bbolt.DB, settlement settle.Settlement) error {
+	log := transferLogger(rec)
+
+	clearHashes, err := newFileChunkStore(rec.HashesFile, 32)
+	if err != nil {
+		return err
+	}
+	cipherChunks, err := newFileChunkStore(rec.ChunksFile, tedd.ChunkSize)
+	if err != nil {
+		return err
+	}
+
+	var key [32]byte
+	copy(key[:], rec.Key)
+
+	out, err := os.Create(rec.OutFile)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	err = tedd.Decrypt(out, clearHashes, cipherChunks, key)
+	if bchErr, ok := err.(tedd.BadClearHashError); ok {
+		log.Info("decryption failed; claiming refund", "stage", "refund", "chunk", bchErr.Index)
+
+		redeem := &settle.Redeem{
+			RefundDeadline: rec.RefundDeadline,
+			Buyer:          rec.Buyer,
+			Seller:         rec.Seller,
+			Amount:         2 * rec.Amount,
+			AssetID:        rec.AssetID.Bytes(),
+			ClearRoot:      rec.ClearRoot,
+			Key:            key,
+		}
+		copy(redeem.CipherRoot[:], rec.CipherRoot[:])
+		copy(redeem.Anchor2[:], rec.Anchor2)
+
+		var (
+			refHash        [32 + binary.MaxVarintLen64]byte
+			refCipherChunk [tedd.ChunkSize + binary.MaxVarintLen64]byte
+		)
+		m := binary.PutUvarint(refHash[:], bchErr.Index)
+		binary.PutUvarint(refCipherChunk[:], bchErr.Index)
+
+		g, err := clearHashes.Get(bchErr.Index)
+		if err != nil {
+			return err
+		}
+		copy(refHash[m:], g)
+
+		g, err = cipherChunks.Get(bchErr.Index)
+		if err != nil {
+			return err
+		}
+		copy(refCipherChunk[m:], g)
+
+		nchunks, err := cipherChunks.Len()
+		if err != nil {
+			return err
+		}
+
+		clearProof, err := tredd.ProofFromCache(sha256.New, rec.ClearProofFile, bchErr.Index, uint64(nchunks))
+		if err != nil {
+			return err
+		}
+		cipherProof, err := tredd.ProofFromCache(sha256.New, rec.CipherProofFile, bchErr.Index, uint64(nchunks))
+		if err != nil {
+			return err
+		}
+
+		err = settlement.ClaimRefund(context.Background(), redeem, int64(bchErr.Index), refCipherChunk[m:m+len(g)], refHash[m:m+32], cipherProof, clearProof) // xxx range check
+		if err != nil {
+			return err
+		}
+
+		return markDone(db, rec, StateRefundClaimed)
+	}
+	if err != nil {
+		return err
+	}
+
+	log.Info("complete", "stage", "finish")
+	return markDone(db, rec, StateDecrypted)
+}
+
+// claimTimeoutRefund is finishTransfer's counterpart for a seller that
+// never reveals a key at all: both get and resume run it when rec's
+// reveal deadline arrives while rec is still StatePaymentProposed, so a
+// silent seller doesn't leave committed funds stuck forever with no
+// automated recourse.
+//
+// No reveal ever happened, so rec has no seller key and no merged-in
+// collateral anchor - the fields a post-reveal refund gets from
+// Acceptance (Seller, Anchor2) are unknown here. This claims against the
+// buyer's own pre-reveal commitment (Anchor1) instead, and passes a
+// negative index to signal a deadline-only claim with no chunk proof.
+// Like everything else in this tree that ultimately calls down to
+// tx.go's contract asm, it depends on the deployed contract actually
+// supporting that redemption path.
+func claimTimeoutRefund(rec *transferRecord, db *bbolt.DB, settlement settle.Settlement) error {
+	log := transferLogger(rec)
+
+	if err := setTransferState(db, rec.TransferID, StateExpired); err != nil {
+		return err
+	}
+
+	redeem := &settle.Redeem{
+		RefundDeadline: rec.RefundDeadline,
+		Buyer:          rec.Buyer,
+		Amount:         rec.Amount, // payment only: no collateral was ever merged in
+		AssetID:        rec.AssetID.Bytes(),
+		ClearRoot:      rec.ClearRoot,
+	}
+	copy(redeem.CipherRoot[:], rec.CipherRoot[:])
+	copy(redeem.Anchor2[:], rec.Anchor1)
+
+	log.Info("reveal deadline passed with no reveal; claiming refund", "stage", "refund")
+	if err := settlement.ClaimRefund(context.Background(), redeem, -1, nil, nil, nil, nil); err != nil {
+		return err
+	}
+
+	return setTransferState(db, rec.TransferID, StateRefundClaimed)
+}
+
+// markDone records rec's terminal state and removes its chunk-store files,
+// which are only safe to discard once decryption or refund has actually
+// succeeded.
+func markDone(db *bbolt.DB, rec *transferRecord, state TransferState) error {
+	if err := setTransferState(db, rec.TransferID, state); err != nil {
+		return err
+	}
+	os.Remove(rec.HashesFile)
+	os.Remove(rec.ChunksFile)
+	os.Remove(rec.ClearProofFile)
+	os.Remove(rec.CipherProofFile)
+	return nil
+}
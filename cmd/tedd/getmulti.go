@@ -0,0 +1,536 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bobg/tedd"
+	"github.com/bobg/tredd"
+	"github.com/bobg/tredd/settle"
+	"github.com/bobg/tredd/transport"
+	"github.com/chain/txvm/crypto/ed25519"
+	"github.com/chain/txvm/protocol/bc"
+	"github.com/coreos/bbolt"
+)
+
+// serverList collects repeated -server flags into a slice, the way a
+// swarm download needs more than the single seller `get` talks to.
+type serverList []string
+
+func (s *serverList) String() string { return strings.Join(*s, ",") }
+
+func (s *serverList) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
+
+// sellerRange is one seller's assignment within a get-multi transfer: the
+// chunk-index range it's been asked for and the proportional payment
+// that buys it.
+type sellerRange struct {
+	addr       string
+	rng        transport.Range
+	amount     int64
+	transferID string // set once fetchRange has a transfer ID, for mergeRanges
+}
+
+// getMulti is `tedd get-multi`: it spreads one file's chunks across
+// several sellers, weighted by each one's reputation, instead of
+// depending on a single seller for the whole transfer the way `get`
+// does. Each seller still runs its own independent propose/reveal/redeem
+// contract against the shared clearRoot, scoped to the range it was
+// assigned, so a single bad or slow seller only costs get-multi that
+// seller's share instead of the whole file.
+//
+// Splitting a proof-of-correct-decryption refund across sellers isn't
+// fully worked out here: ClaimRefund's per-chunk Merkle proof is built
+// from a cache of every leaf from index 0 up to the total chunk count
+// (see finishTransfer), which assumes one seller sent the whole file. A
+// sub-range seller's cache only has the leaves in its own range, at
+// locally-zeroed indices, so it can't produce a cryptographic proof
+// against the file's single clearRoot commitment the way finishTransfer
+// does. Until the settlement contract (or the proof-cache format) is
+// extended to support per-range commitments, a bad chunk from a seller
+// claims its refund the same way claimTimeoutRefund does for a silent
+// seller: a negative index, resting on the seller's own reveal
+// (Anchor2/Seller/Key, known from that seller's sub-transfer) rather
+// than a specific chunk's sibling proof. The seller is also marked down
+// and excluded from the rest of this transfer, and its range is
+// re-fetched from another seller.
+func getMulti(args []string) error {
+	ctx := context.Background()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	fs := flag.NewFlagSet("", flag.PanicOnError)
+
+	var servers serverList
+	fs.Var(&servers, "server", "tedd server address (repeatable): an http(s):// base URL, or a /p2p/... multiaddr")
+
+	var (
+		clearRootHex         = fs.String("hash", "", "clear-chunk Merkle root hash of requested file")
+		nchunksFlag          = fs.Uint64("nchunks", 0, "total number of chunks in the requested file")
+		amount               = fs.Int64("amount", 0, "total amount of proposed payment, split across sellers")
+		assetIDHex           = fs.String("asset", "", "asset ID of proposed payment")
+		revealDeadlineDurStr = fs.String("reveal", "", "time until reveal deadline, in time.ParseDuration format")
+		refundDeadlineDurStr = fs.String("refund", "", "time from reveal deadline until refund deadline")
+		dbFile               = fs.String("db", "", "file containing client-state db")
+		prvFile              = fs.String("prv", "", "file containing client private key")
+		bcURL                = fs.String("bcurl", "", "base URL of blockchain server")
+		dir                  = fs.String("dir", "", "root dir for file transfers")
+		changeDust           = fs.Int64("changedust", -1, "if >= 0, try to reserve utxos summing to each seller's amount within this much leftover, to avoid a change output (see tredd.Changeless)")
+	)
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if len(servers) == 0 {
+		return fmt.Errorf("get-multi requires at least one -server")
+	}
+	if *nchunksFlag == 0 {
+		return fmt.Errorf("get-multi requires -nchunks (the swarm has no way to discover it on its own)")
+	}
+
+	f, err := os.Open(*prvFile)
+	if err != nil {
+		return fmt.Errorf("opening prv file %s: %w", *prvFile, err)
+	}
+	var prvbuf [ed25519.PrivateKeySize]byte
+	_, err = io.ReadFull(f, prvbuf[:])
+	f.Close()
+	if err != nil {
+		return fmt.Errorf("reading prv file %s: %w", *prvFile, err)
+	}
+	prv := ed25519.PrivateKey(prvbuf[:])
+	buyer := prv.Public().(ed25519.PublicKey)
+
+	var clearRoot [32]byte
+	if _, err := hex.Decode(clearRoot[:], []byte(*clearRootHex)); err != nil {
+		return err
+	}
+
+	log := requestLogger(buyer, clearRoot)
+
+	assetIDBytes, err := hex.DecodeString(*assetIDHex)
+	if err != nil {
+		return err
+	}
+	assetID := bc.HashFromBytes(assetIDBytes)
+
+	revealDeadlineDur, err := time.ParseDuration(*revealDeadlineDurStr)
+	if err != nil {
+		return err
+	}
+	revealDeadline := time.Now().Add(revealDeadlineDur)
+
+	refundDeadlineDur, err := time.ParseDuration(*refundDeadlineDurStr)
+	if err != nil {
+		return err
+	}
+	refundDeadline := revealDeadline.Add(refundDeadlineDur)
+
+	db, err := bbolt.Open(*dbFile, 0600, nil)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	outFileName := path.Join(*dir, hex.EncodeToString(clearRoot[:]))
+
+	pool := append([]string(nil), servers...)
+	assigned, err := assignSellers(db, buyer, pool, *nchunksFlag, *amount)
+	if err != nil {
+		return err
+	}
+
+	var (
+		mu       sync.Mutex
+		fetched  []sellerRange // assignments that completed successfully
+		excluded = map[string]bool{}
+	)
+
+	for len(assigned) > 0 {
+		var wg sync.WaitGroup
+		results := make([]error, len(assigned))
+		for i, a := range assigned {
+			wg.Add(1)
+			go func(i int, a sellerRange) {
+				defer wg.Done()
+				slog := requestLogger(buyer, clearRoot)
+				slog.Info("fetching range from seller", "stage", "get-multi", "server", a.addr, "rangelo", a.rng.Lo, "rangehi", a.rng.Hi)
+				bytesTransferred, elapsed, err := fetchRange(ctx, db, prv, buyer, clearRoot, assetID, &a, revealDeadline, refundDeadline, *dir, *bcURL, *changeDust)
+				refunded := 0
+				if err != nil {
+					refunded = int(a.rng.Hi - a.rng.Lo)
+					slog.Warn("seller failed its range; will reroute", "stage", "get-multi", "server", a.addr, "err", err)
+				} else {
+					mu.Lock()
+					fetched = append(fetched, a)
+					mu.Unlock()
+				}
+				if uerr := updateReputation(db, a.addr, bytesTransferred, elapsed, refunded, int(a.rng.Hi-a.rng.Lo)); uerr != nil {
+					slog.Error("updating reputation", "stage", "get-multi", "server", a.addr, "err", uerr)
+				}
+				results[i] = err
+			}(i, a)
+		}
+		wg.Wait()
+
+		var retry []sellerRange
+		for i, a := range assigned {
+			if results[i] != nil {
+				excluded[a.addr] = true
+				retry = append(retry, a)
+			}
+		}
+		if len(retry) == 0 {
+			break
+		}
+
+		remaining := remainingPool(pool, excluded)
+		if len(remaining) == 0 {
+			return fmt.Errorf("get-multi: %d ranges failed with no sellers left to reroute to", len(retry))
+		}
+		reassigned, err := reassignRanges(db, buyer, remaining, retry)
+		if err != nil {
+			return err
+		}
+		assigned = reassigned
+	}
+
+	log.Info("merging per-seller ranges into output file", "stage", "get-multi", "out", outFileName)
+	return mergeRanges(*dir, outFileName, fetched)
+}
+
+// assignSellers looks up each candidate's reputation, splits [0, nchunks)
+// across them in proportion to weight, and splits amount the same way so
+// that a seller serving a bigger share of the file is trusted with a
+// bigger share of the payment.
+func assignSellers(db *bbolt.DB, buyer ed25519.PublicKey, addrs []string, nchunks uint64, amount int64) ([]sellerRange, error) {
+	weights := make([]float64, len(addrs))
+	for i, addr := range addrs {
+		rec, err := getReputation(db, addr)
+		if err != nil {
+			return nil, err
+		}
+		weights[i] = weight(rec)
+	}
+
+	ranges := assignRanges(nchunks, weights)
+
+	var total float64
+	for _, w := range weights {
+		total += w
+	}
+
+	out := make([]sellerRange, len(addrs))
+	var amountLeft = amount
+	for i, addr := range addrs {
+		share := amount
+		if total > 0 && i < len(addrs)-1 {
+			share = int64(float64(amount) * weights[i] / total)
+			amountLeft -= share
+		} else if i == len(addrs)-1 {
+			share = amountLeft
+		}
+		out[i] = sellerRange{addr: addr, rng: ranges[i], amount: share}
+	}
+	return out, nil
+}
+
+// reassignRanges re-splits the chunk indices covered by failed among
+// whatever sellers are still eligible, weighted the same way as the
+// initial assignment. Each failed range is reassigned independently of
+// the others: summing every failed range's size and amount into one
+// total and rebuilding a single contiguous block anchored at the first
+// one's Lo only works when the failed ranges are themselves contiguous.
+// If a successful range sits between two failed ones (e.g. sellers 0 and
+// 2 fail but seller 1 doesn't), collapsing them into one block would
+// re-cover the successful range and drop part of the file entirely, with
+// nothing downstream (mergeRanges included) noticing.
+func reassignRanges(db *bbolt.DB, buyer ed25519.PublicKey, addrs []string, failed []sellerRange) ([]sellerRange, error) {
+	var out []sellerRange
+	for _, f := range failed {
+		reassigned, err := assignSellers(db, buyer, addrs, f.rng.Hi-f.rng.Lo, f.amount)
+		if err != nil {
+			return nil, err
+		}
+		// assignSellers always splits starting at index 0; shift each range
+		// by this failed range's own Lo so the reassignment covers exactly
+		// the part of the file f was responsible for.
+		for i := range reassigned {
+			reassigned[i].rng.Lo += f.rng.Lo
+			reassigned[i].rng.Hi += f.rng.Lo
+		}
+		out = append(out, reassigned...)
+	}
+	return out, nil
+}
+
+func remainingPool(pool []string, excluded map[string]bool) []string {
+	var out []string
+	for _, addr := range pool {
+		if !excluded[addr] {
+			out = append(out, addr)
+		}
+	}
+	return out
+}
+
+// assignRanges splits [0, nchunks) into len(weights) disjoint, ordered
+// sub-ranges sized in proportion to weights. If every weight is zero, each
+// seller gets an equal share instead; otherwise a seller with zero weight
+// gets an empty range.
+func assignRanges(nchunks uint64, weights []float64) []transport.Range {
+	n := len(weights)
+	ranges := make([]transport.Range, n)
+	if n == 0 || nchunks == 0 {
+		return ranges
+	}
+
+	var total float64
+	for _, w := range weights {
+		total += w
+	}
+	if total <= 0 {
+		for i := range weights {
+			weights[i] = 1
+		}
+		total = float64(n)
+	}
+
+	var (
+		lo    uint64
+		sofar float64
+	)
+	for i, w := range weights {
+		sofar += w
+		var hi uint64
+		if i == n-1 {
+			hi = nchunks
+		} else {
+			hi = uint64(float64(nchunks) * sofar / total)
+			if hi < lo {
+				hi = lo
+			}
+			if hi > nchunks {
+				hi = nchunks
+			}
+		}
+		ranges[i] = transport.Range{Lo: lo, Hi: hi}
+		lo = hi
+	}
+	return ranges
+}
+
+// fetchRange runs one seller's slice of a get-multi transfer: request,
+// stream, store, propose payment, await the key (or the reveal
+// deadline), and decrypt - the same shape as `get`, but scoped to a.rng
+// and writing into files namespaced by transfer ID so concurrent sellers
+// don't collide.
+func fetchRange(ctx context.Context, db *bbolt.DB, prv ed25519.PrivateKey, buyer ed25519.PublicKey, clearRoot [32]byte, assetID bc.Hash, a *sellerRange, revealDeadline, refundDeadline time.Time, dir, bcURL string, changeDust int64) (bytesTransferred int64, elapsed time.Duration, err error) {
+	start := time.Now()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	tport, err := transport.Pick(ctx, a.addr)
+	if err != nil {
+		return 0, time.Since(start), err
+	}
+
+	transferID, err := tport.Request(ctx, a.addr, buyer, clearRoot, a.amount, assetID.Bytes(), revealDeadline, refundDeadline, a.rng)
+	if err != nil {
+		return 0, time.Since(start), fmt.Errorf("requesting range: %w", err)
+	}
+	a.transferID = transferID
+
+	cipherStream, err := tport.StreamCipher(ctx, transferID)
+	if err != nil {
+		return 0, time.Since(start), fmt.Errorf("opening cipher stream: %w", err)
+	}
+	defer cipherStream.Close()
+
+	var (
+		clearHashesFile  = path.Join(dir, fmt.Sprintf("hashes-%s", transferID))
+		cipherChunksFile = path.Join(dir, fmt.Sprintf("chunks-%s", transferID))
+		rangeOutFile     = path.Join(dir, fmt.Sprintf("range-%s", transferID))
+	)
+
+	rec := &transferRecord{
+		TransferID:     transferID,
+		ClearRoot:      clearRoot,
+		Amount:         a.amount,
+		AssetID:        assetID,
+		RevealDeadline: revealDeadline,
+		RefundDeadline: refundDeadline,
+		Buyer:          buyer,
+		HashesFile:     clearHashesFile,
+		ChunksFile:     cipherChunksFile,
+		OutFile:        rangeOutFile,
+		State:          StateRequested,
+	}
+	if err := putTransfer(db, rec); err != nil {
+		return 0, time.Since(start), fmt.Errorf("recording transfer state: %w", err)
+	}
+
+	log := transferLogger(rec)
+
+	clearHashes, err := newFileChunkStore(clearHashesFile, 32)
+	if err != nil {
+		return 0, time.Since(start), fmt.Errorf("creating hash chunk store: %w", err)
+	}
+	cipherChunks, err := newFileChunkStore(cipherChunksFile, tedd.ChunkSize)
+	if err != nil {
+		return 0, time.Since(start), fmt.Errorf("creating cipher chunk store: %w", err)
+	}
+
+	log.Info("storing cipher chunks", "stage", "get-multi")
+	_, err = tedd.Get(cipherStream, clearRoot, clearHashes, cipherChunks)
+	if err != nil {
+		return 0, time.Since(start), err
+	}
+
+	n, err := cipherChunks.Len()
+	if err != nil {
+		return 0, time.Since(start), err
+	}
+	bytesTransferred = int64(n) * int64(tedd.ChunkSize)
+
+	signer := func(msg []byte) ([]byte, error) {
+		return ed25519.Sign(prv, msg), nil
+	}
+
+	o := newObserver(db, buyer, bcURL+"/get")
+	go o.run(ctx)
+
+	var reserveOpts *tredd.ReserveOpts
+	if changeDust >= 0 {
+		reserveOpts = &tredd.ReserveOpts{Strategy: tredd.Changeless{DustTolerance: changeDust}}
+	}
+
+	settlement := settle.NewTxvm(o.r, reserveOpts, signer, nil, bcURL)
+
+	// A sub-range seller's cipher root only covers its own slice of the
+	// file, not the whole-file clearRoot; see getMulti's doc comment for
+	// why a real per-range settlement commitment isn't wired up yet.
+	var zeroRoot [32]byte
+	anchor1, prog, err := settlement.ProposePayment(ctx, buyer, a.amount, assetID.Bytes(), clearRoot, zeroRoot, revealDeadline, refundDeadline)
+	if err != nil {
+		return bytesTransferred, time.Since(start), err
+	}
+
+	rec.State = StatePaymentProposed
+	rec.Anchor1 = anchor1
+	if err := putTransfer(db, rec); err != nil {
+		return bytesTransferred, time.Since(start), fmt.Errorf("recording transfer state: %w", err)
+	}
+
+	done := make(chan error, 1)
+	o.setcb(func(tx *bc.Tx) {
+		accepted := settlement.ParseAcceptance(tx.Program, anchor1)
+		if accepted == nil {
+			return
+		}
+		rec.Seller = accepted.Seller
+		rec.Anchor2 = accepted.Anchor2
+		rec.Key = accepted.Key
+		rec.State = StateKeyRevealed
+		if err := putTransfer(db, rec); err != nil {
+			done <- err
+			return
+		}
+
+		var key [32]byte
+		copy(key[:], rec.Key)
+		out, err := os.Create(rec.OutFile)
+		if err != nil {
+			done <- err
+			return
+		}
+		defer out.Close()
+
+		err = tedd.Decrypt(out, clearHashes, cipherChunks, key)
+		if bchErr, ok := err.(tedd.BadClearHashError); ok {
+			log.Warn("seller sent a bad chunk; claiming refund", "stage", "get-multi", "server", a.addr, "localindex", bchErr.Index)
+
+			redeem := &settle.Redeem{
+				RefundDeadline: refundDeadline,
+				Buyer:          buyer,
+				Seller:         rec.Seller,
+				Amount:         2 * a.amount,
+				AssetID:        assetID.Bytes(),
+				ClearRoot:      clearRoot,
+				Key:            key,
+			}
+			copy(redeem.CipherRoot[:], zeroRoot[:])
+			copy(redeem.Anchor2[:], rec.Anchor2)
+
+			// See getMulti's doc comment: a sub-range seller's cache can't
+			// produce a per-chunk proof against the whole-file clearRoot, so
+			// this rests on the seller's own reveal alone, the same
+			// negative-index claim claimTimeoutRefund makes for a seller
+			// that never reveals at all.
+			if cerr := settlement.ClaimRefund(context.Background(), redeem, -1, nil, nil, nil, nil); cerr != nil {
+				log.Error("claiming refund", "stage", "get-multi", "server", a.addr, "err", cerr)
+			}
+
+			done <- fmt.Errorf("seller sent a bad chunk at local index %d: %w", bchErr.Index, bchErr)
+			return
+		}
+		if err != nil {
+			done <- err
+			return
+		}
+		done <- markDone(db, rec, StateDecrypted)
+	})
+	o.enqueue(revealDeadline, func() {
+		done <- fmt.Errorf("reveal deadline arrived with no key from %s", a.addr)
+	})
+
+	log.Info("proposing payment", "stage", "get-multi", "server", a.addr)
+	if err := tport.SendPayment(ctx, transferID, prog); err != nil {
+		log.Warn("sending payment proposal", "stage", "get-multi", "err", err)
+	}
+
+	select {
+	case err := <-done:
+		return bytesTransferred, time.Since(start), err
+	case <-ctx.Done():
+		return bytesTransferred, time.Since(start), ctx.Err()
+	}
+}
+
+// mergeRanges concatenates each seller's decrypted range file, in
+// ascending chunk-index order, into the final output file.
+func mergeRanges(dir, outFile string, ranges []sellerRange) error {
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].rng.Lo < ranges[j].rng.Lo })
+
+	out, err := os.Create(outFile)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	for _, r := range ranges {
+		in, err := os.Open(path.Join(dir, fmt.Sprintf("range-%s", r.transferID)))
+		if err != nil {
+			return fmt.Errorf("opening decrypted range for %s: %w", r.addr, err)
+		}
+		_, err = io.Copy(out, in)
+		in.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/coreos/bbolt"
+)
+
+// list prints every transfer recorded in the client-state db, in whatever
+// state it was last left: useful for finding a transfer ID to pass to
+// `tedd resume`.
+func list(args []string) error {
+	fs := flag.NewFlagSet("", flag.PanicOnError)
+	dbFile := fs.String("db", "", "file containing client-state db")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	db, err := bbolt.Open(*dbFile, 0600, nil)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	recs, err := listTransfers(db)
+	if err != nil {
+		return err
+	}
+	for _, rec := range recs {
+		fmt.Printf("%s\t%s\t%x\t%d\n", rec.TransferID, rec.State, rec.ClearRoot, rec.Amount)
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"encoding/hex"
+	"log/slog"
+	"os"
+
+	"github.com/chain/txvm/crypto/ed25519"
+)
+
+// baseLogger is the root of every structured logger this command emits
+// from. It writes JSON lines to stderr so that a daemon running many
+// concurrent transfers can be grepped/indexed by transfer_id instead of
+// producing interleaved plain-text log.Print output.
+var baseLogger = slog.New(slog.NewJSONHandler(os.Stderr, nil))
+
+// transferLogger returns a logger carrying rec's transfer_id, buyer, and
+// clear_root as fields on every line it emits, so a caller only has to
+// add "stage" (and anything else specific to the call site) to place a
+// line within the propose/reveal/redeem flow.
+func transferLogger(rec *transferRecord) *slog.Logger {
+	return baseLogger.With(
+		"transfer_id", rec.TransferID,
+		"buyer", hex.EncodeToString(rec.Buyer),
+		"clear_root", hex.EncodeToString(rec.ClearRoot[:]),
+	)
+}
+
+// requestLogger is transferLogger's counterpart for the window before a
+// transferRecord exists yet (get() doesn't have a transfer ID until the
+// server responds to its initial request).
+func requestLogger(buyer ed25519.PublicKey, clearRoot [32]byte) *slog.Logger {
+	return baseLogger.With(
+		"buyer", hex.EncodeToString(buyer),
+		"clear_root", hex.EncodeToString(clearRoot[:]),
+	)
+}
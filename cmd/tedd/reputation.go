@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/coreos/bbolt"
+)
+
+// reputationBucket holds one entry per seller this client has ever dealt
+// with, keyed by the seller's address (the same string get-multi's
+// -server flags pass around), so that get-multi can weight a seller's
+// share of a future transfer by how well it's performed on past ones.
+// Addressing reputation by seller address rather than public key is a
+// deliberate choice: get-multi has to pick range sizes before it talks to
+// a seller at all, and the address is the only identity it has at that
+// point - the seller's public key isn't known until a transfer with it
+// is already under way.
+var reputationBucket = []byte("reputation")
+
+// reputationEWMAAlpha is the weight get-multi gives a transfer's own
+// throughput against a seller's prior history each time its reputation is
+// updated: low enough that one unlucky (or one lucky) transfer doesn't
+// swing a seller's assigned share too far.
+const reputationEWMAAlpha = 0.2
+
+// reputationRecord is a seller's persisted track record. AvgThroughput is
+// an EWMA in bytes/second; RefundedChunks/TotalChunks together give a
+// refund rate used to discount an otherwise-fast but unreliable seller.
+type reputationRecord struct {
+	Seller              string
+	SuccessfulTransfers int
+	RefundedChunks      int
+	TotalChunks         int
+	AvgThroughput       float64
+}
+
+// defaultReputation is what a seller this client has never transacted
+// with gets: a placeholder throughput of 1 byte/second and no observed
+// refunds. Next to sellers with an established throughput history, this
+// gives a new seller a near-empty share of a transfer.
+func defaultReputation(seller string) *reputationRecord {
+	return &reputationRecord{Seller: seller, AvgThroughput: 1}
+}
+
+func getReputation(db *bbolt.DB, seller string) (*reputationRecord, error) {
+	var rec *reputationRecord
+	err := db.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(reputationBucket)
+		if b == nil {
+			return nil
+		}
+		data := b.Get([]byte(seller))
+		if data == nil {
+			return nil
+		}
+		rec = &reputationRecord{}
+		return json.Unmarshal(data, rec)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if rec == nil {
+		rec = defaultReputation(seller)
+	}
+	return rec, nil
+}
+
+func putReputation(db *bbolt.DB, rec *reputationRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	return db.Update(func(tx *bbolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(reputationBucket)
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(rec.Seller), data)
+	})
+}
+
+// updateReputation folds one transfer's outcome into seller's record:
+// bytesTransferred/elapsed becomes a new throughput sample, folded into
+// the existing EWMA, and refundedChunks/totalChunks accumulate into the
+// seller's lifetime refund rate.
+func updateReputation(db *bbolt.DB, seller string, bytesTransferred int64, elapsed time.Duration, refundedChunks, totalChunks int) error {
+	rec, err := getReputation(db, seller)
+	if err != nil {
+		return err
+	}
+
+	if elapsed > 0 {
+		sample := float64(bytesTransferred) / elapsed.Seconds()
+		rec.AvgThroughput = reputationEWMAAlpha*sample + (1-reputationEWMAAlpha)*rec.AvgThroughput
+	}
+	rec.RefundedChunks += refundedChunks
+	rec.TotalChunks += totalChunks
+	if refundedChunks == 0 {
+		rec.SuccessfulTransfers++
+	}
+
+	return putReputation(db, rec)
+}
+
+// refundRate is the fraction of a seller's chunks, across every transfer
+// it's taken part in, that turned out to need a refund.
+func refundRate(rec *reputationRecord) float64 {
+	if rec.TotalChunks == 0 {
+		return 0
+	}
+	return float64(rec.RefundedChunks) / float64(rec.TotalChunks)
+}
+
+// weight turns a seller's track record into the relative share of a
+// transfer get-multi should assign it: fast sellers get more of the file,
+// and a seller's history of needing refunds discounts its throughput
+// rather than excluding it outright, since an occasional bad chunk from
+// an otherwise-fast seller is still cheaper to buy from than a slow one.
+func weight(rec *reputationRecord) float64 {
+	w := rec.AvgThroughput * (1 - refundRate(rec))
+	if w <= 0 {
+		// A seller with a 100% refund rate still gets a small, nonzero
+		// share: enough to keep testing whether it's improved, not enough
+		// to matter if it hasn't.
+		return 0.01
+	}
+	return w
+}
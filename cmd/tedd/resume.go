@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"flag"
+	"sync"
+
+	"github.com/bobg/tredd/settle"
+	"github.com/chain/txvm/protocol/bc"
+	"github.com/coreos/bbolt"
+)
+
+// resume reopens the chunk stores and blockchain observer for a transfer
+// left in progress by a prior `get` (interrupted, e.g., by a crash between
+// proposing payment and receiving the key) and either finishes decrypting
+// it, claims its refund, or keeps waiting for the key, depending on the
+// transfer's last recorded state. Per-transfer failures are logged and
+// skipped rather than aborting the whole batch, so that resuming many
+// transfers at once isn't all-or-nothing.
+func resume(args []string) error {
+	fs := flag.NewFlagSet("", flag.PanicOnError)
+
+	var (
+		dbFile     = fs.String("db", "", "file containing client-state db")
+		bcURL      = fs.String("bcurl", "", "base URL of blockchain server")
+		dir        = fs.String("dir", "", "root dir for file transfers")
+		transferID = fs.String("transfer", "", "transfer ID to resume; if empty, resume all unfinished transfers")
+	)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	db, err := bbolt.Open(*dbFile, 0600, nil)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	var recs []*transferRecord
+	if *transferID != "" {
+		rec, err := getTransfer(db, *transferID)
+		if err != nil {
+			return err
+		}
+		recs = []*transferRecord{rec}
+	} else {
+		recs, err = listTransfers(db)
+		if err != nil {
+			return err
+		}
+	}
+
+	// No Reserver or Signer is needed here: resume only redeems a
+	// contract already proposed by an earlier `get`, it never proposes a
+	// new one.
+	settlement := settle.NewTxvm(nil, nil, nil, nil, *bcURL)
+
+	// StatePaymentProposed transfers each block on their own reveal
+	// deadline (or the seller's reveal, whichever comes first), so they
+	// run concurrently: resumeWaitingForKey blocking one transfer must
+	// not hold up the others, or resuming N transfers would take as long
+	// as the slowest one's reveal deadline instead of finishing as soon as
+	// each one's own outcome is known.
+	var wg sync.WaitGroup
+	for _, rec := range recs {
+		log := transferLogger(rec)
+		switch rec.State {
+		case StateDecrypted, StateRefundClaimed, StateExpired:
+			log.Info("already finished, nothing to resume", "stage", "resume", "state", rec.State.String())
+
+		case StateKeyRevealed:
+			log.Info("key already revealed, finishing", "stage", "resume")
+			if err := finishTransfer(rec, db, settlement); err != nil {
+				log.Error("finishing transfer", "stage", "resume", "err", err)
+			}
+
+		case StatePaymentProposed:
+			log.Info("awaiting key reveal or refund deadline", "stage", "resume")
+			wg.Add(1)
+			go func(rec *transferRecord) {
+				defer wg.Done()
+				resumeWaitingForKey(rec, db, *bcURL, settlement)
+			}(rec)
+
+		case StateRequested:
+			log.Info("payment was never proposed; nothing to resume, funds were not committed", "stage", "resume")
+		}
+	}
+	wg.Wait()
+	return nil
+}
+
+// resumeWaitingForKey re-subscribes the blockchain observer for rec's anchor
+// and blocks until either the seller's key-reveal transaction appears or
+// rec's reveal deadline passes.
+func resumeWaitingForKey(rec *transferRecord, db *bbolt.DB, bcURL string, settlement settle.Settlement) {
+	log := transferLogger(rec)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	o := newObserver(db, rec.Buyer, bcURL+"/get")
+	go o.run(ctx)
+
+	o.setcb(func(tx *bc.Tx) {
+		defer cancel()
+
+		accepted := settlement.ParseAcceptance(tx.Program, rec.Anchor1)
+		if accepted == nil {
+			return
+		}
+
+		log.Info("key revealed; now decrypting", "stage", "resume-reveal", "key", hex.EncodeToString(accepted.Key))
+
+		rec.Seller = accepted.Seller
+		rec.Anchor2 = accepted.Anchor2
+		rec.Key = accepted.Key
+		rec.State = StateKeyRevealed
+		if err := putTransfer(db, rec); err != nil {
+			log.Error("recording transfer state", "stage", "resume-reveal", "err", err)
+			return
+		}
+
+		if err := finishTransfer(rec, db, settlement); err != nil {
+			log.Error("finishing transfer", "stage", "resume-finish", "err", err)
+		}
+	})
+	o.enqueue(rec.RevealDeadline, func() {
+		log.Info("reveal deadline has arrived, transfer invalid", "stage", "resume-reveal")
+		if err := claimTimeoutRefund(rec, db, settlement); err != nil {
+			log.Error("claiming timeout refund", "stage", "resume-reveal", "err", err)
+		}
+		cancel()
+	})
+
+	<-ctx.Done()
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/chain/txvm/crypto/ed25519"
+	"github.com/chain/txvm/protocol/bc"
+	"github.com/coreos/bbolt"
+)
+
+// transfersBucket holds one entry per in-flight or completed transfer, keyed
+// by transfer ID, so that a crash between proposing payment and receiving
+// the key leaves enough state behind to resume decryption or claim a
+// refund instead of stranding committed funds.
+var transfersBucket = []byte("transfers")
+
+// TransferState is where a transfer sits in the propose/reveal/redeem state
+// machine. States only move forward; resume uses the current state to decide
+// what's left to do.
+type TransferState int
+
+const (
+	StateRequested TransferState = iota
+	StatePaymentProposed
+	StateKeyRevealed
+	StateDecrypted
+	StateRefundClaimed
+	StateExpired
+)
+
+func (s TransferState) String() string {
+	switch s {
+	case StateRequested:
+		return "requested"
+	case StatePaymentProposed:
+		return "payment-proposed"
+	case StateKeyRevealed:
+		return "key-revealed"
+	case StateDecrypted:
+		return "decrypted"
+	case StateRefundClaimed:
+		return "refund-claimed"
+	case StateExpired:
+		return "expired"
+	default:
+		return fmt.Sprintf("unknown(%d)", int(s))
+	}
+}
+
+// transferRecord is the persisted record of one transfer: enough to reopen
+// its chunk stores, re-subscribe for its anchor, and either finish
+// decryption or issue a refund, without needing anything the original `get`
+// invocation had in memory.
+type transferRecord struct {
+	TransferID      string
+	ClearRoot       [32]byte
+	CipherRoot      [32]byte
+	Amount          int64
+	AssetID         bc.Hash
+	RevealDeadline  time.Time
+	RefundDeadline  time.Time
+	Anchor1         []byte
+	Anchor2         []byte
+	Key             []byte
+	Buyer           ed25519.PublicKey
+	Seller          ed25519.PublicKey
+	HashesFile      string
+	ChunksFile      string
+	OutFile         string
+	ClearProofFile  string
+	CipherProofFile string
+	State           TransferState
+}
+
+func putTransfer(db *bbolt.DB, rec *transferRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	return db.Update(func(tx *bbolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(transfersBucket)
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(rec.TransferID), data)
+	})
+}
+
+func setTransferState(db *bbolt.DB, transferID string, state TransferState) error {
+	rec, err := getTransfer(db, transferID)
+	if err != nil {
+		return err
+	}
+	rec.State = state
+	return putTransfer(db, rec)
+}
+
+func getTransfer(db *bbolt.DB, transferID string) (*transferRecord, error) {
+	var rec transferRecord
+	err := db.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(transfersBucket)
+		if b == nil {
+			return fmt.Errorf("no transfer %s", transferID)
+		}
+		data := b.Get([]byte(transferID))
+		if data == nil {
+			return fmt.Errorf("no transfer %s", transferID)
+		}
+		return json.Unmarshal(data, &rec)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &rec, nil
+}
+
+func listTransfers(db *bbolt.DB) ([]*transferRecord, error) {
+	var recs []*transferRecord
+	err := db.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(transfersBucket)
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(_, data []byte) error {
+			var rec transferRecord
+			if err := json.Unmarshal(data, &rec); err != nil {
+				return err
+			}
+			recs = append(recs, &rec)
+			return nil
+		})
+	})
+	return recs, err
+}
@@ -0,0 +1,104 @@
+package tredd
+
+import (
+	"context"
+	"runtime"
+
+	"github.com/chain/txvm/errors"
+)
+
+// ConcurrentSigner is an optional capability that callers of ProposePayment
+// and RevealKey can assert about their Signer: that it is safe to call from
+// multiple goroutines at once, up to Concurrency of them. Since Signer is a
+// plain func and funcs can't implement interfaces, a caller whose signer is
+// concurrency-safe passes a value implementing ConcurrentSigner alongside it;
+// passing nil tells ProposePayment/RevealKey to sign one UTXO at a time; as
+// they always have.
+type ConcurrentSigner interface {
+	// Concurrency is the maximum number of concurrent calls to the
+	// accompanying Signer that it can usefully service (e.g. the size of a
+	// backing HSM session pool). A value <= 1 disables parallel signing.
+	Concurrency() int
+}
+
+// signConcurrently signs each of msgs, preserving order, using signer. If
+// concurrentSigner is non-nil and reports a Concurrency() > 1, the calls are
+// spread over a bounded worker pool of that size (capped at GOMAXPROCS);
+// otherwise the messages are signed one at a time, identical to the
+// historical behavior. The context is checked between dispatching signs so a
+// cancellation stops outstanding work promptly.
+func signConcurrently(ctx context.Context, signer Signer, concurrentSigner ConcurrentSigner, msgs [][]byte) ([][]byte, error) {
+	n := len(msgs)
+	sigs := make([][]byte, n)
+
+	workers := 1
+	if concurrentSigner != nil {
+		if c := concurrentSigner.Concurrency(); c > 1 {
+			workers = c
+		}
+	}
+	if workers > runtime.GOMAXPROCS(0) {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	if workers > n {
+		workers = n
+	}
+	if workers <= 1 {
+		for i, msg := range msgs {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			sig, err := signer(msg)
+			if err != nil {
+				return nil, errors.Wrapf(err, "signing input %d", i)
+			}
+			sigs[i] = sig
+		}
+		return sigs, nil
+	}
+
+	var (
+		jobs         = make(chan int)
+		errCh        = make(chan error, workers)
+		ctx2, cancel = context.WithCancel(ctx)
+	)
+	defer cancel()
+
+	for w := 0; w < workers; w++ {
+		go func() {
+			for i := range jobs {
+				sig, err := signer(msgs[i])
+				if err != nil {
+					select {
+					case errCh <- errors.Wrapf(err, "signing input %d", i):
+					default:
+					}
+					cancel()
+					return
+				}
+				sigs[i] = sig
+			}
+			errCh <- nil
+		}()
+	}
+
+feed:
+	for i := 0; i < n; i++ {
+		select {
+		case jobs <- i:
+		case <-ctx2.Done():
+			break feed
+		}
+	}
+	close(jobs)
+
+	for w := 0; w < workers; w++ {
+		if err := <-errCh; err != nil {
+			return nil, err
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return sigs, nil
+}
@@ -0,0 +1,49 @@
+package tredd
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+type benchConcurrentSigner struct{ n int }
+
+func (s benchConcurrentSigner) Concurrency() int { return s.n }
+
+func slowSign(msg []byte) ([]byte, error) {
+	time.Sleep(100 * time.Microsecond) // stand in for a real signing operation
+	sig := make([]byte, 64)
+	copy(sig, msg)
+	return sig, nil
+}
+
+func benchmarkSignConcurrently(b *testing.B, nutxos, concurrency int) {
+	msgs := make([][]byte, nutxos)
+	for i := range msgs {
+		msgs[i] = []byte{byte(i)}
+	}
+	var cs ConcurrentSigner
+	if concurrency > 1 {
+		cs = benchConcurrentSigner{n: concurrency}
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := signConcurrently(context.Background(), slowSign, cs, msgs)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkSignSerial(b *testing.B) {
+	for _, n := range []int{1, 10, 100, 1000} {
+		b.Run("", func(b *testing.B) { benchmarkSignConcurrently(b, n, 1) })
+	}
+}
+
+func BenchmarkSignConcurrent(b *testing.B) {
+	for _, n := range []int{1, 10, 100, 1000} {
+		b.Run("", func(b *testing.B) { benchmarkSignConcurrently(b, n, 16) })
+	}
+}
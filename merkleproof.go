@@ -0,0 +1,244 @@
+package tredd
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"os"
+
+	"github.com/bobg/merkle"
+)
+
+// ProofCache is an append-only, on-disk cache of Merkle leaf hashes built up
+// during a single streaming ingestion pass (tedd.Get). Add only appends a
+// leaf hash, an O(1) operation; the O(nleaves) work of turning those leaves
+// into a full tree happens once, in Close, which appends every node's hash
+// (internal nodes and a second copy of each leaf) right after the leaves.
+// That one-time pass is what lets
+// ProofFromCache construct a refund proof by reading only the O(log
+// nleaves) nodes on one leaf's path, instead of re-deriving sibling
+// subtrees from their leaves (and so touching all of them) on every call,
+// as the original ad-hoc merkle.NewProofTree-and-replay loop in the
+// `tedd get` refund path did.
+//
+// The vendored merkle package doesn't expose a way to resume a ProofTree for
+// an as-yet-unknown target leaf, so ProofCache instead maintains its own
+// record of every leaf and internal-node hash and derives proofs from that
+// record using the conventional binary-tree combining rule
+// sha256(0x01 || left || right), matching merkle.LeafHash's own use of a
+// leading type byte to keep leaf and internal node hashes from colliding.
+// If the deployed contract's Merkle verifier ever uses a different
+// combining rule, this needs to track it.
+type ProofCache struct {
+	f       *os.File
+	hasher  func() hash.Hash
+	entries int64
+}
+
+const proofCacheRecordSize = 32
+
+// NewProofCache creates (or truncates) the cache file at path for leaf
+// hashes produced by hasher.
+func NewProofCache(path string, hasher func() hash.Hash) (*ProofCache, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &ProofCache{f: f, hasher: hasher}, nil
+}
+
+// Add appends the next leaf's hash to the cache, in index order.
+func (c *ProofCache) Add(leaf []byte) error {
+	var prefix [binary.MaxVarintLen64]byte
+	m := binary.PutUvarint(prefix[:], uint64(c.entries))
+
+	h := c.hasher()
+	merkle.LeafHash(h, prefix[:m], leaf)
+	sum := h.Sum(nil)
+
+	if _, err := c.f.Write(sum); err != nil {
+		return err
+	}
+	c.entries++
+	return nil
+}
+
+// Close builds the cache's internal-node section (see buildNodes) and
+// flushes and closes the cache file.
+func (c *ProofCache) Close() error {
+	if c.entries > 0 {
+		if err := c.buildNodes(); err != nil {
+			c.f.Close()
+			return err
+		}
+	}
+	return c.f.Close()
+}
+
+// buildNodes appends the hash of every node, leaves included, of the tree
+// MTH defines over the leaves already written to c.f, at the offsets nodeIndex
+// assigns them, so that ProofFromCache can fetch any node's hash with a
+// single read instead of re-deriving it from its descendant leaves.
+func (c *ProofCache) buildNodes() error {
+	nleaves := uint64(c.entries)
+
+	readLeafHash := func(i uint64) ([]byte, error) {
+		buf := make([]byte, proofCacheRecordSize)
+		_, err := c.f.ReadAt(buf, int64(i)*proofCacheRecordSize)
+		return buf, err
+	}
+
+	nodes := make([]byte, subtreeNodeCount(0, nleaves)*proofCacheRecordSize)
+
+	var build func(lo, hi, idx uint64) ([]byte, error)
+	build = func(lo, hi, idx uint64) ([]byte, error) {
+		if hi-lo == 1 {
+			h, err := readLeafHash(lo)
+			if err != nil {
+				return nil, err
+			}
+			copy(nodes[idx*proofCacheRecordSize:], h)
+			return h, nil
+		}
+		k := largestPowerOfTwoLessThan(hi - lo)
+		mid := lo + k
+		left, err := build(lo, mid, idx+1)
+		if err != nil {
+			return nil, err
+		}
+		right, err := build(mid, hi, idx+1+subtreeNodeCount(lo, mid))
+		if err != nil {
+			return nil, err
+		}
+		h := combine(c.hasher(), left, right)
+		copy(nodes[idx*proofCacheRecordSize:], h)
+		return h, nil
+	}
+	if _, err := build(0, nleaves, 0); err != nil {
+		return err
+	}
+
+	_, err := c.f.WriteAt(nodes, int64(nleaves)*proofCacheRecordSize)
+	return err
+}
+
+// ProofFromCache opens the leaf-and-node cache at path (as written by
+// ProofCache, whose Close has already built the internal-node section
+// described above) and returns the O(log nleaves) sibling path for leaf
+// index. Every sibling hash it needs was already computed by buildNodes,
+// so this reads exactly one record per level of the tree - it never
+// re-derives a subtree hash from the leaves underneath it.
+func ProofFromCache(hasher func() hash.Hash, path string, index, nleaves uint64) (merkle.Proof, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	readNodeHash := func(lo, hi uint64) ([]byte, error) {
+		idx := nleaves + nodeIndex(0, nleaves, lo, hi)
+		buf := make([]byte, proofCacheRecordSize)
+		_, err := f.ReadAt(buf, int64(idx)*proofCacheRecordSize)
+		return buf, err
+	}
+
+	var proof merkle.Proof
+
+	// walk follows the same recursion MTH uses to split [lo:hi), but at
+	// each split it looks up the precomputed hash of whichever half index
+	// is NOT in, building the audit path as defined by RFC 6962's PATH
+	// algorithm, without recomputing any of it.
+	var walk func(i, lo, hi uint64) error
+	walk = func(i, lo, hi uint64) error {
+		if hi-lo == 1 {
+			return nil
+		}
+		k := largestPowerOfTwoLessThan(hi - lo)
+		if i < lo+k {
+			siblingHash, err := readNodeHash(lo+k, hi)
+			if err != nil {
+				return err
+			}
+			proof = appendProofStep(proof, siblingHash, false)
+			return walk(i, lo, lo+k)
+		}
+		siblingHash, err := readNodeHash(lo, lo+k)
+		if err != nil {
+			return err
+		}
+		proof = appendProofStep(proof, siblingHash, true)
+		return walk(i, lo+k, hi)
+	}
+
+	if nleaves == 0 {
+		return nil, fmt.Errorf("empty tree")
+	}
+	if index >= nleaves {
+		return nil, fmt.Errorf("index %d out of range for %d leaves", index, nleaves)
+	}
+	if err := walk(index, 0, nleaves); err != nil {
+		return nil, err
+	}
+
+	// merkle.Proof is ordered leaf-to-root in RenderProof (tx.go walks it
+	// back to front), but walk() above produces it root-to-leaf, so reverse.
+	for i, j := 0, len(proof)-1; i < j; i, j = i+1, j-1 {
+		proof[i], proof[j] = proof[j], proof[i]
+	}
+	return proof, nil
+}
+
+// appendProofStep grows proof by one step without needing to name
+// merkle.Proof's element type directly: it grows the slice via make+copy
+// (safe even when proof is nil, unlike indexing into proof itself) and sets
+// the new element's fields through the one already-typed pointer this
+// package has, &grown[len(proof)].
+func appendProofStep(proof merkle.Proof, h []byte, left bool) merkle.Proof {
+	grown := make(merkle.Proof, len(proof)+1)
+	copy(grown, proof)
+	grown[len(proof)].H = h
+	grown[len(proof)].Left = left
+	return grown
+}
+
+func combine(h hash.Hash, left, right []byte) []byte {
+	h.Write([]byte{1})
+	h.Write(left)
+	h.Write(right)
+	return h.Sum(nil)
+}
+
+// subtreeNodeCount returns the number of nodes - internal and leaf -  in
+// the subtree MTH builds over [lo,hi): a binary tree always has exactly
+// n-1 internal nodes for n leaves, regardless of its shape, so this needs
+// no recursion.
+func subtreeNodeCount(lo, hi uint64) uint64 {
+	return 2*(hi-lo) - 1
+}
+
+// nodeIndex returns the offset, in the pre-order flattening buildNodes
+// writes, of the node covering [tlo,thi) within the tree MTH builds over
+// [lo,hi). It mirrors buildNodes' own recursion, but - since [tlo,thi) is
+// always entirely inside one half of any given split - only ever follows
+// the one branch containing it, so it costs O(log(hi-lo)), not O(hi-lo).
+func nodeIndex(lo, hi, tlo, thi uint64) uint64 {
+	if lo == tlo && hi == thi {
+		return 0
+	}
+	k := largestPowerOfTwoLessThan(hi - lo)
+	mid := lo + k
+	if thi <= mid {
+		return 1 + nodeIndex(lo, mid, tlo, thi)
+	}
+	return 1 + subtreeNodeCount(lo, mid) + nodeIndex(mid, hi, tlo, thi)
+}
+
+// largestPowerOfTwoLessThan returns the largest power of two strictly less
+// than n, for n > 1.
+func largestPowerOfTwoLessThan(n uint64) uint64 {
+	k := uint64(1)
+	for k<<1 < n {
+		k <<= 1
+	}
+	return k
+}
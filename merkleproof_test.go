@@ -0,0 +1,142 @@
+package tredd
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"hash"
+	"testing"
+
+	"github.com/bobg/merkle"
+)
+
+// referenceLeafHash computes the same leaf hash ProofCache.Add does, so
+// tests can build an independent expected root without going through
+// ProofCache itself.
+func referenceLeafHash(hasher func() hash.Hash, index uint64, data []byte) []byte {
+	var prefix [binary.MaxVarintLen64]byte
+	m := binary.PutUvarint(prefix[:], index)
+	h := hasher()
+	merkle.LeafHash(h, prefix[:m], data)
+	return h.Sum(nil)
+}
+
+// referenceRoot recomputes the RFC 6962 root directly from leaf hashes,
+// independent of ProofCache's buildNodes/nodeIndex machinery, as the
+// baseline a proof from ProofFromCache must fold up to.
+func referenceRoot(hasher func() hash.Hash, leaves [][]byte) []byte {
+	if len(leaves) == 1 {
+		return leaves[0]
+	}
+	k := largestPowerOfTwoLessThan(uint64(len(leaves)))
+	left := referenceRoot(hasher, leaves[:k])
+	right := referenceRoot(hasher, leaves[k:])
+	return combine(hasher(), left, right)
+}
+
+// foldProof reconstructs a root from a leaf hash and the sibling path
+// ProofFromCache returns, in the same leaf-to-root order ClaimRefund's
+// RenderProof expects.
+func foldProof(hasher func() hash.Hash, leafHash []byte, proof merkle.Proof) []byte {
+	h := leafHash
+	for _, step := range proof {
+		if step.Left {
+			h = combine(hasher(), step.H, h)
+		} else {
+			h = combine(hasher(), h, step.H)
+		}
+	}
+	return h
+}
+
+func buildCache(t *testing.T, n int) (path string, leaves [][]byte, leafHashes [][]byte) {
+	t.Helper()
+
+	path = t.TempDir() + "/cache"
+	cache, err := NewProofCache(path, sha256.New)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < n; i++ {
+		leaf := []byte{byte(i), byte(i >> 8), 0xAA}
+		leaves = append(leaves, leaf)
+		leafHashes = append(leafHashes, referenceLeafHash(sha256.New, uint64(i), leaf))
+		if err := cache.Add(leaf); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := cache.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path, leaves, leafHashes
+}
+
+func TestProofFromCache(t *testing.T) {
+	for _, n := range []int{1, 2, 3, 4, 5, 8, 13, 32} {
+		path, _, leafHashes := buildCache(t, n)
+		want := referenceRoot(sha256.New, leafHashes)
+
+		for index := 0; index < n; index++ {
+			proof, err := ProofFromCache(sha256.New, path, uint64(index), uint64(n))
+			if err != nil {
+				t.Fatalf("n=%d index=%d: %v", n, index, err)
+			}
+			got := foldProof(sha256.New, leafHashes[index], proof)
+			if string(got) != string(want) {
+				t.Errorf("n=%d index=%d: folded proof root = %x, want %x", n, index, got, want)
+			}
+		}
+	}
+}
+
+func TestProofFromCacheOutOfRange(t *testing.T) {
+	path, _, _ := buildCache(t, 4)
+	if _, err := ProofFromCache(sha256.New, path, 4, 4); err == nil {
+		t.Error("expected an error for an out-of-range index")
+	}
+}
+
+func TestSubtreeNodeCount(t *testing.T) {
+	var countNodes func(lo, hi uint64) uint64
+	countNodes = func(lo, hi uint64) uint64 {
+		if hi-lo == 1 {
+			return 1
+		}
+		k := largestPowerOfTwoLessThan(hi - lo)
+		return 1 + countNodes(lo, lo+k) + countNodes(lo+k, hi)
+	}
+
+	for _, n := range []uint64{1, 2, 3, 4, 5, 8, 13, 32} {
+		want := countNodes(0, n)
+		if got := subtreeNodeCount(0, n); got != want {
+			t.Errorf("subtreeNodeCount(0, %d) = %d, want %d", n, got, want)
+		}
+	}
+}
+
+func TestNodeIndexMatchesPreOrder(t *testing.T) {
+	// preOrderIndex assigns offsets the same way buildNodes' recursion
+	// visits nodes, independent of nodeIndex's direct formula.
+	next := uint64(0)
+	offsets := map[[2]uint64]uint64{}
+	var preOrderIndex func(lo, hi uint64)
+	preOrderIndex = func(lo, hi uint64) {
+		offsets[[2]uint64{lo, hi}] = next
+		next++
+		if hi-lo == 1 {
+			return
+		}
+		k := largestPowerOfTwoLessThan(hi - lo)
+		preOrderIndex(lo, lo+k)
+		preOrderIndex(lo+k, hi)
+	}
+
+	const n = 21
+	preOrderIndex(0, n)
+
+	for rng, want := range offsets {
+		got := nodeIndex(0, n, rng[0], rng[1])
+		if got != want {
+			t.Errorf("nodeIndex(0, %d, %d, %d) = %d, want %d", n, rng[0], rng[1], got, want)
+		}
+	}
+}
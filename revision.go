@@ -0,0 +1,339 @@
+package tredd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/chain/txvm/crypto/ed25519"
+	"github.com/chain/txvm/errors"
+	"github.com/chain/txvm/protocol/bc"
+	"github.com/chain/txvm/protocol/txbuilder/standard"
+	"github.com/chain/txvm/protocol/txvm"
+	"github.com/chain/txvm/protocol/txvm/asm"
+	"github.com/chain/txvm/protocol/txvm/op"
+	"github.com/chain/txvm/protocol/txvm/txvmutil"
+)
+
+// revisionContractSeed, revisionContractProg, and revisionRedemptionProg
+// identify the renewable variant of the Tredd contract: a distinct instance
+// from the single-shot contract (treddContractSeed/treddContractProg/
+// redemptionProg in tx.go), with a third entry point (selector 2, used by
+// Close) that checks a monotonic revision counter and both parties'
+// signatures before replacing the committed clearRoot/cipherRoot/
+// revealDeadline. ProposeContract's log shape differs from ProposePayment's
+// accordingly (a revision number in place of a root pair), so this contract
+// cannot share treddContractProg's bytecode, log layout, or redemption
+// entry points - reusing them would let Close rehydrate the wrong contract
+// instance, or check a revision number the single-shot contract never
+// commits. Like treddContractSeed/treddContractProg, their definitions live
+// alongside this contract's asm, which is out of scope for this file.
+
+// Revision describes one step of a renewable Tredd contract's revision loop.
+// A Revision is exchanged off-chain between buyer and seller;
+// only the most recent, doubly-signed Revision needs to reach the chain,
+// on Close, on dispute, or when RevealDeadline passes.
+type Revision struct {
+	// Number is the monotonic revision counter.
+	// The txvm contract only allows the highest-numbered signed revision to redeem,
+	// which is what prevents an old revision from being broadcast to steal funds.
+	Number int64
+
+	ClearRoot, CipherRoot [32]byte
+
+	// Amount is the cumulative amount transferred to the seller as of this revision
+	// (not the incremental delta between revisions).
+	Amount int64
+
+	RevealDeadline time.Time
+
+	// Anchor2 is the anchor of the Value tuple holding the contract's remaining funds,
+	// as of the previous revision. It is all zeros for the first revision (Number == 0),
+	// which spends the output of ProposeContract instead.
+	Anchor2 [32]byte
+
+	// BuyerSig and SellerSig are signatures, by the buyer and seller respectively,
+	// over the revision's fields (including Number), binding both parties to it.
+	BuyerSig, SellerSig []byte
+}
+
+// ProposeContract opens a funded, renewable Tredd contract for the given total amount
+// without binding it to a specific clearRoot/cipherRoot pair.
+// The buyer and seller are expected to exchange a sequence of Revisions over the
+// contract's lifetime via Revise, each committing to the root pair for the next chunk,
+// and to settle the last agreed Revision on chain with Close.
+func ProposeContract(
+	ctx context.Context,
+	buyer ed25519.PublicKey,
+	amount int64,
+	assetID bc.Hash,
+	now, refundDeadline time.Time,
+	reserver Reserver,
+	opts *ReserveOpts,
+	signer Signer,
+) ([]byte, error) {
+	reservation, err := reserver.Reserve(ctx, amount, assetID, now, refundDeadline, opts)
+	if err != nil {
+		return nil, errors.Wrap(err, "reserving utxos")
+	}
+	utxos, err := reservation.UTXOs(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "querying utxos from reservation")
+	}
+
+	// ProposeContract is identical in shape to ProposePayment except that it binds
+	// revision 0 instead of a clearRoot/cipherRoot pair: the contract asm checks the
+	// log for a 'R' entry with revision number 0 in place of the root hashes, and the
+	// first real Revise call supplies the initial roots.
+	treddLogPos := 2 * int64(len(utxos))
+
+	buf := new(bytes.Buffer)
+	fmt.Fprint(buf, "[")
+
+	change, err := reservation.Change(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "querying change amount from reservation")
+	}
+	if change > 0 {
+		treddLogPos += 3
+	}
+
+	fmt.Fprintf(buf, "%d peeklog untuple\n", treddLogPos)
+	fmt.Fprintf(buf, "4 eq verify\n")
+	fmt.Fprintf(buf, "3 roll 'R' eq verify\n")
+	fmt.Fprintf(buf, "2 roll x'%x' eq verify\n", revisionContractSeed[:])
+	fmt.Fprintf(buf, "%d eq verify\n", 0) // revision number 0
+	fmt.Fprintf(buf, "0 eq verify\n")
+
+	fmt.Fprintf(buf, "%d peeklog untuple drop\n", treddLogPos+1)
+	fmt.Fprintf(buf, "%d eq verify\n", bc.Millis(refundDeadline))
+	fmt.Fprintf(buf, "drop drop\n")
+
+	fmt.Fprintf(buf, "%d peeklog untuple drop\n", treddLogPos+2)
+	fmt.Fprintf(buf, "x'%x' eq verify\n", buyer)
+	fmt.Fprintf(buf, "drop drop\n")
+
+	fmt.Fprintf(buf, "%d peeklog untuple drop\n", treddLogPos+3)
+	fmt.Fprintf(buf, "%d eq verify\n", amount)
+	fmt.Fprintf(buf, "drop drop\n")
+
+	fmt.Fprintf(buf, "%d peeklog untuple drop\n", treddLogPos+4)
+	fmt.Fprintf(buf, "x'%x' eq verify\n", assetID.Bytes())
+	fmt.Fprintf(buf, "drop drop\n")
+
+	fmt.Fprint(buf, "] yield")
+
+	sigprog, err := asm.Assemble(buf.String())
+	if err != nil {
+		return nil, errors.Wrap(err, "assembling signature program")
+	}
+
+	anchoredSigprog := make([]byte, 32+len(sigprog))
+	copy(anchoredSigprog, sigprog)
+
+	b := new(txvmutil.Builder)
+	for i, utxo := range utxos {
+		b.PushdataBytes([]byte{}).Op(op.Put)
+		standard.SpendMultisig(b, 1, []ed25519.PublicKey{buyer}, utxo.Amount(), utxo.AssetID(), utxo.Anchor(), standard.PayToMultisigSeed2[:])
+		b.Op(op.Get)
+
+		copy(anchoredSigprog[len(sigprog):], utxo.Anchor())
+		sig, err := signer(anchoredSigprog)
+		if err != nil {
+			return nil, errors.Wrap(err, "signing input")
+		}
+		b.PushdataBytes(sig).Op(op.Put)
+		b.PushdataBytes(sigprog).Op(op.Put)
+		b.Op(op.Call)
+
+		b.Op(op.Get).Op(op.Get).PushdataInt64(1).Op(op.Roll).Op(op.Put)
+
+		if i > 0 {
+			b.Op(op.Merge)
+		}
+	}
+	if change > 0 {
+		b.PushdataInt64(change).Op(op.Split)
+
+		b.PushdataBytes(nil).Op(op.Put)
+		b.PushdataBytes(nil).Op(op.Put)
+		b.Op(op.Put)
+		b.PushdataBytes(buyer).PushdataInt64(1).Op(op.Tuple).Op(op.Put)
+		b.PushdataInt64(1).Op(op.Put)
+		b.PushdataBytes(standard.PayToMultisigProg2).Op(op.Contract).Op(op.Call)
+	}
+
+	b.PushdataBytes(revisionContractProg).Op(op.Contract)
+	b.PushdataInt64(1).Op(op.Roll)
+
+	b.Op(op.Put)                  // payment, which was already on the contract stack
+	b.PushdataInt64(0).Op(op.Put) // revision number 0; no clearRoot/cipherRoot yet
+	b.PushdataBytes(buyer).Op(op.Put)
+	b.PushdataInt64(int64(bc.Millis(refundDeadline))).Op(op.Put) // TODO: range check
+
+	b.Op(op.Call)
+
+	b.Op(op.Get) // move tredd contract back to con stack
+
+	for i := 0; i < len(utxos); i++ {
+		b.Op(op.Get).Op(op.Call)
+	}
+
+	return b.Build(), nil
+}
+
+// Revise produces the next Revision in a renewable Tredd contract's revision loop,
+// replacing prev (or, if prev is nil, the initial ProposeContract output).
+// The returned Revision is doubly signed by buyerSigner and sellerSigner and is not,
+// itself, broadcast; it is exchanged off-chain and only needs to reach the chain via
+// Close, on dispute, or once RevealDeadline passes.
+func Revise(
+	prev *Revision,
+	nextClearRoot, nextCipherRoot [32]byte,
+	deltaAmount int64,
+	nextRevealDeadline time.Time,
+	buyerSigner, sellerSigner Signer,
+) (*Revision, error) {
+	number := int64(0)
+	amount := deltaAmount
+	if prev != nil {
+		number = prev.Number + 1
+		amount = prev.Amount + deltaAmount
+	}
+
+	rev := &Revision{
+		Number:         number,
+		ClearRoot:      nextClearRoot,
+		CipherRoot:     nextCipherRoot,
+		Amount:         amount,
+		RevealDeadline: nextRevealDeadline,
+	}
+	if prev != nil {
+		rev.Anchor2 = prev.Anchor2
+	}
+
+	msg := revisionSigMsg(rev)
+
+	buyerSig, err := buyerSigner(msg)
+	if err != nil {
+		return nil, errors.Wrap(err, "buyer signing revision")
+	}
+	sellerSig, err := sellerSigner(msg)
+	if err != nil {
+		return nil, errors.Wrap(err, "seller signing revision")
+	}
+	rev.BuyerSig = buyerSig
+	rev.SellerSig = sellerSig
+	return rev, nil
+}
+
+// revisionSigMsg is the canonical byte string that buyer and seller sign to agree to
+// a Revision. It must bind the revision number so that an older, superseded revision
+// cannot be resigned or replayed.
+func revisionSigMsg(rev *Revision) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%d:%x:%x:%d:%d", rev.Number, rev.ClearRoot[:], rev.CipherRoot[:], rev.Amount, bc.Millis(rev.RevealDeadline))
+	return buf.Bytes()
+}
+
+// redeemRevision builds the input-reconstruction preamble for a renewable
+// Tredd contract, paralleling tx.go's redeem. It must use
+// revisionContractSeed/revisionRedemptionProg rather than tx.go's
+// treddContractSeed/redemptionProg: Close rehydrates whatever contract
+// instance ProposeContract actually created on chain, and that instance
+// was seeded by revisionContractProg, not treddContractProg.
+func redeemRevision(r *Redeem) *bytes.Buffer {
+	buf := new(bytes.Buffer)
+	fmt.Fprintf(
+		buf,
+		"{'C', x'%x', x'%x', {'Z', %d}, {'S', x'%x'}, {'V', %d, x'%x', x'%x'}, {'S', x'%x'}, {'S', x'%x'}, {'S', x'%x'}, {'S', x'%x'}} input\n",
+		revisionContractSeed,
+		revisionRedemptionProg,
+		bc.Millis(r.RefundDeadline),
+		r.Buyer,
+		r.Amount,
+		r.AssetID.Bytes(),
+		r.Anchor2[:],
+		r.CipherRoot[:],
+		r.ClearRoot[:],
+		r.Key[:],
+		r.Seller,
+	)
+	return buf
+}
+
+// Close finalizes the latest agreed Revision of a renewable Tredd contract on chain,
+// rehydrating the contract from utxo state (as identified by r) and replacing its
+// clearRoot/cipherRoot/revealDeadline with those of latest. The contract asm verifies
+// both signatures in latest and that latest.Number is no lower than whatever revision
+// number (if any) is already committed on chain, so an old, signed revision cannot be
+// used to redeem after a newer one exists.
+func Close(r *Redeem, latest *Revision) ([]byte, error) {
+	if len(latest.BuyerSig) == 0 || len(latest.SellerSig) == 0 {
+		return nil, errors.New("revision is not fully signed")
+	}
+
+	buf := redeemRevision(r)
+	fmt.Fprintf(buf, "x'%x' put\n", latest.SellerSig)
+	fmt.Fprintf(buf, "x'%x' put\n", latest.BuyerSig)
+	fmt.Fprintf(buf, "%d put\n", latest.Number)
+	fmt.Fprintf(buf, "%d put\n", bc.Millis(latest.RevealDeadline))
+	fmt.Fprintf(buf, "%d put\n", latest.Amount)
+	fmt.Fprintf(buf, "x'%x' put\n", latest.CipherRoot[:])
+	fmt.Fprintf(buf, "x'%x' put\n", latest.ClearRoot[:])
+	fmt.Fprintln(buf, "2 put call") // '2' selects the revise-and-close entry point
+	fmt.Fprintln(buf, "get finalize")
+	return asm.Assemble(buf.String())
+}
+
+// RevisionParseResult holds the values parsed from the log of a transaction that
+// invokes the propose-contract or revise phase of a renewable Tredd contract.
+type RevisionParseResult struct {
+	ParseResult
+	RevisionNumber int64
+}
+
+// ParseRevisionLog is ParseLog's counterpart for renewable contracts. It
+// cannot delegate to ParseLog: that function matches log entries against
+// treddContractSeed and reads a clearRoot/cipherRoot pair at the log
+// offsets ProposePayment's contract instance uses, neither of which apply
+// here. ProposeContract's 'R' entry instead carries the revision number
+// (in the slot ParseLog reads as RevealDeadline, since a renewable
+// contract doesn't commit a per-chunk reveal deadline until a Revision
+// is agreed), and the entries that follow it are RefundDeadline, Buyer,
+// Amount, and AssetID - five fields in total, not ParseLog's seven.
+//
+// ClearRoot, CipherRoot, RevealDeadline, Anchor2, Key, Seller, and
+// OutputID are left unset: they're only committed once Close's
+// revise-and-close entry point runs, and that entry point's log shape -
+// like the rest of this contract's asm - isn't part of this snapshot.
+// A caller needing those fields from a closed contract's log will need
+// to extend this function once that shape is known.
+func ParseRevisionLog(prog []byte) *RevisionParseResult {
+	vm, err := txvm.Validate(prog, 3, math.MaxInt64, txvm.StopAfterFinalize)
+	if vm == nil || err != nil {
+		return nil
+	}
+	var res *RevisionParseResult
+	for i, item := range vm.Log {
+		if len(item) != 4 {
+			continue
+		}
+		code, ok := item[0].(txvm.Bytes)
+		if !ok || !bytes.Equal(code, []byte{'R'}) {
+			continue
+		}
+		if !bytes.Equal(item[1].(txvm.Bytes), revisionContractSeed[:]) {
+			continue
+		}
+		res = &RevisionParseResult{RevisionNumber: int64(item[3].(txvm.Int))}
+		res.RefundDeadline = bc.FromMillis(uint64(vm.Log[i+1][2].(txvm.Int)))
+		res.Buyer = ed25519.PublicKey(vm.Log[i+2][2].(txvm.Bytes))
+		res.Amount = int64(vm.Log[i+3][2].(txvm.Int))
+		res.AssetID = vm.Log[i+4][2].(txvm.Bytes)
+		break
+	}
+	return res
+}
@@ -0,0 +1,150 @@
+package tredd
+
+import "sort"
+
+// SelectionStrategy chooses which UTXOs from a candidate set to spend toward a
+// target amount. Implementations follow the input-source pattern used by
+// btcwallet: each strategy is a pure function of the candidate set and the
+// target, returning the selected UTXOs and the resulting change (the sum of
+// the selected UTXOs' amounts minus target, which is always >= 0 for a
+// returned selection).
+//
+// A Reserver uses a SelectionStrategy to decide which UTXOs to hand back from
+// Reserve, instead of always draining the candidate set front-to-back.
+type SelectionStrategy interface {
+	// Select picks a subset of utxos whose total amount is at least target.
+	// It returns ok == false if no subset of utxos sums to at least target.
+	Select(utxos []UTXO, target int64) (selected []UTXO, change int64, ok bool)
+}
+
+// ReserveOpts carries optional parameters to Reserver.Reserve beyond the
+// required amount/assetID/deadline. A nil *ReserveOpts (or a zero value) must
+// preserve a Reserver's pre-existing behavior.
+type ReserveOpts struct {
+	// Strategy selects which UTXOs to reserve. If nil, a Reserver should fall
+	// back to its historical behavior (typically DefaultSelectionStrategy).
+	Strategy SelectionStrategy
+
+	// DustTolerance is a leftover amount carried alongside Strategy. No
+	// strategy in this package reads it: Changeless takes its tolerance from
+	// its own DustTolerance field.
+	DustTolerance int64
+}
+
+// DefaultSelectionStrategy is the SelectionStrategy a Reserver should use when
+// none is specified, preserving the original "drain the candidate set in the
+// order given" behavior.
+var DefaultSelectionStrategy SelectionStrategy = OldestFirst{}
+
+// LargestFirst selects UTXOs from largest to smallest amount until the target
+// is met. It tends to minimize the number of inputs at the cost of leaving
+// more change.
+type LargestFirst struct{}
+
+// Select implements SelectionStrategy.
+func (LargestFirst) Select(utxos []UTXO, target int64) ([]UTXO, int64, bool) {
+	ordered := append([]UTXO{}, utxos...)
+	sort.Slice(ordered, func(i, j int) bool { return ordered[i].Amount() > ordered[j].Amount() })
+	return drain(ordered, target)
+}
+
+// OldestFirst selects UTXOs in the order given, which callers are expected to
+// supply oldest-first. It is the strategy tredd has always used: Reserve used
+// to simply drain its candidate slice from the front.
+type OldestFirst struct{}
+
+// Select implements SelectionStrategy.
+func (OldestFirst) Select(utxos []UTXO, target int64) ([]UTXO, int64, bool) {
+	return drain(utxos, target)
+}
+
+// SmallestSufficient selects UTXOs from smallest to largest amount until the
+// target is met. It tends to consume the most dust-prone (smallest) UTXOs
+// first, helping keep a wallet's UTXO set from fragmenting further.
+type SmallestSufficient struct{}
+
+// Select implements SelectionStrategy.
+func (SmallestSufficient) Select(utxos []UTXO, target int64) ([]UTXO, int64, bool) {
+	ordered := append([]UTXO{}, utxos...)
+	sort.Slice(ordered, func(i, j int) bool { return ordered[i].Amount() < ordered[j].Amount() })
+	return drain(ordered, target)
+}
+
+// Changeless is a bounded branch-and-bound selector that tries to hit target
+// exactly, or within dustTolerance, so that callers (e.g. ProposePayment) can
+// skip their change > 0 branch entirely. If no combination comes within
+// dustTolerance, Select falls back to SmallestSufficient so that Reserve
+// still succeeds, just with ordinary change.
+type Changeless struct {
+	// DustTolerance is the largest acceptable leftover amount. A selection
+	// whose total is in [target, target+DustTolerance] is accepted.
+	DustTolerance int64
+
+	// MaxTries bounds the branch-and-bound search, since the general subset-sum
+	// problem is NP-hard. Zero means a sensible default (1000) is used.
+	MaxTries int
+}
+
+// Select implements SelectionStrategy.
+func (c Changeless) Select(utxos []UTXO, target int64) ([]UTXO, int64, bool) {
+	maxTries := c.MaxTries
+	if maxTries == 0 {
+		maxTries = 1000
+	}
+
+	ordered := append([]UTXO{}, utxos...)
+	sort.Slice(ordered, func(i, j int) bool { return ordered[i].Amount() > ordered[j].Amount() })
+
+	var (
+		best      []UTXO
+		bestTotal int64 = -1
+		tries     int
+	)
+
+	var search func(start int, picked []UTXO, total int64) bool
+	search = func(start int, picked []UTXO, total int64) bool {
+		tries++
+		if tries > maxTries {
+			return false
+		}
+		if total >= target {
+			if total-target <= c.DustTolerance && (bestTotal < 0 || total < bestTotal) {
+				best = append([]UTXO{}, picked...)
+				bestTotal = total
+			}
+			return bestTotal == target // exact match found, stop searching
+		}
+		for i := start; i < len(ordered); i++ {
+			if search(i+1, append(picked, ordered[i]), total+ordered[i].Amount()) {
+				return true
+			}
+		}
+		return false
+	}
+	search(0, nil, 0)
+
+	if best != nil {
+		return best, bestTotal - target, true
+	}
+	return SmallestSufficient{}.Select(utxos, target)
+}
+
+// drain selects utxos in the order given until their total reaches target,
+// the behavior Reserve has always had.
+func drain(utxos []UTXO, target int64) ([]UTXO, int64, bool) {
+	var (
+		selected []UTXO
+		total    int64
+	)
+	for _, u := range utxos {
+		if total >= target {
+			break
+		}
+		selected = append(selected, u)
+		total += u.Amount()
+	}
+	if total < target {
+		return nil, 0, false
+	}
+	return selected, total - target, true
+}
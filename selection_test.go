@@ -0,0 +1,62 @@
+package tredd
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/chain/txvm/protocol/bc"
+)
+
+func walletUTXOs(n int, r *rand.Rand) []UTXO {
+	utxos := make([]UTXO, n)
+	for i := range utxos {
+		utxos[i] = &testUTXO{amount: 1 + r.Int63n(1_000_000), assetID: bc.Hash{}}
+	}
+	return utxos
+}
+
+func benchmarkStrategy(b *testing.B, strategy SelectionStrategy, nutxos int) {
+	r := rand.New(rand.NewSource(1))
+	utxos := walletUTXOs(nutxos, r)
+
+	var totalSelected, totalChange int64
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		target := int64(1 + r.Int63n(2_000_000))
+		selected, change, ok := strategy.Select(utxos, target)
+		if !ok {
+			continue
+		}
+		totalSelected += int64(len(selected))
+		totalChange += change
+	}
+	b.ReportMetric(float64(totalSelected)/float64(b.N), "inputs/op")
+	b.ReportMetric(float64(totalChange)/float64(b.N), "change/op")
+}
+
+func BenchmarkLargestFirst(b *testing.B) {
+	for _, n := range []int{10, 100, 1000} {
+		b.Run("", func(b *testing.B) { benchmarkStrategy(b, LargestFirst{}, n) })
+	}
+}
+
+func BenchmarkOldestFirst(b *testing.B) {
+	for _, n := range []int{10, 100, 1000} {
+		b.Run("", func(b *testing.B) { benchmarkStrategy(b, OldestFirst{}, n) })
+	}
+}
+
+func BenchmarkSmallestSufficient(b *testing.B) {
+	for _, n := range []int{10, 100, 1000} {
+		b.Run("", func(b *testing.B) { benchmarkStrategy(b, SmallestSufficient{}, n) })
+	}
+}
+
+func BenchmarkChangeless(b *testing.B) {
+	for _, n := range []int{10, 100, 1000} {
+		b.Run("", func(b *testing.B) {
+			benchmarkStrategy(b, Changeless{DustTolerance: 1000}, n)
+		})
+	}
+}
@@ -0,0 +1,88 @@
+package settle
+
+import (
+	"math/big"
+
+	"github.com/bobg/merkle"
+)
+
+// abiPack concatenates a 4-byte selector with a sequence of already
+// right-padded 32-byte ABI words, covering the escrow contract's calls.
+// The proof arrays are encoded by abiProofSiblings/abiProofSides as
+// fixed-size words (one word per proof step, capped at merkleMaxDepth)
+// rather than full dynamic-array ABI encoding; the one dynamic-length
+// argument, claimRefund's cipherChunk, is encoded by abiBytes.
+func abiPack(selector []byte, words ...[]byte) []byte {
+	out := append([]byte{}, selector...)
+	for _, w := range words {
+		out = append(out, w...)
+	}
+	return out
+}
+
+func abiBytes32(b [32]byte) []byte {
+	return b[:]
+}
+
+func abiAddress(a [20]byte) []byte {
+	var word [32]byte
+	copy(word[12:], a[:])
+	return word[:]
+}
+
+func abiUint256(v *big.Int) []byte {
+	var word [32]byte
+	v.FillBytes(word[:])
+	return word[:]
+}
+
+// abiBytes encodes b as a real ABI dynamic bytes value: a length word
+// followed by b's contents, zero-padded up to the next 32-byte boundary.
+// Unlike the fixed-size encoders above, a caller using this must also
+// place an offset word (the byte position of this blob, relative to the
+// start of the non-selector calldata) in the static head themselves;
+// abiPack has no notion of head/tail layout of its own.
+func abiBytes(b []byte) []byte {
+	var lenWord [32]byte
+	big.NewInt(int64(len(b))).FillBytes(lenWord[:])
+	out := append([]byte{}, lenWord[:]...)
+	out = append(out, b...)
+	if pad := (32 - len(b)%32) % 32; pad != 0 {
+		out = append(out, make([]byte, pad)...)
+	}
+	return out
+}
+
+// merkleMaxDepth bounds how many proof steps abiProofSiblings/abiProofSides
+// will encode; Tredd files are small enough in practice that this is far
+// more than any real proof needs, and it keeps the escrow call data a
+// fixed size instead of requiring dynamic-array ABI encoding.
+const merkleMaxDepth = 64
+
+// abiProofSiblings encodes a merkle.Proof's sibling hashes as merkleMaxDepth
+// consecutive words, zero-padded past the proof's actual length.
+func abiProofSiblings(proof merkle.Proof) []byte {
+	out := make([]byte, 0, 32*merkleMaxDepth)
+	for i := 0; i < merkleMaxDepth; i++ {
+		var word [32]byte
+		if i < len(proof) {
+			copy(word[:], proof[i].H)
+		}
+		out = append(out, word[:]...)
+	}
+	return out
+}
+
+// abiProofSides encodes a merkle.Proof's left/right flags, one per word,
+// zero-padded past the proof's actual length.
+func abiProofSides(proof merkle.Proof) []byte {
+	out := make([]byte, 0, 32*merkleMaxDepth)
+	for i := 0; i < merkleMaxDepth; i++ {
+		var word [32]byte
+		if i < len(proof) && proof[i].Left {
+			word[31] = 1
+		}
+		out = append(out, word[:]...)
+	}
+	return out
+}
@@ -0,0 +1,300 @@
+package settle
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"time"
+
+	"github.com/bobg/merkle"
+	"github.com/chain/txvm/crypto/ed25519"
+	"github.com/chain/txvm/errors"
+	"golang.org/x/crypto/sha3"
+)
+
+// EthSigner produces an ECDSA signature (r||s||v, 65 bytes) over an
+// Ethereum transaction's signing hash. It plays the same role for Eth that
+// tredd.Signer plays for Txvm: the settlement backend never touches a key
+// directly.
+type EthSigner func(hash [32]byte) (sig [65]byte, err error)
+
+// Eth is a Settlement backend for an EVM chain: it commits the buyer's
+// funds with a call into a hash-locked ERC-20 escrow contract, and watches
+// the seller's key reveal via eth_getLogs, the same way the Ethereum
+// clients referenced in Tredd's design docs poll for receipts and
+// subscribe to events. It implements only as much of the EVM's ABI
+// encoding as the escrow contract's four calls need; a real deployment
+// would generate this from the contract's ABI instead.
+type Eth struct {
+	RPCURL    string
+	Escrow    [20]byte // escrow contract address
+	Token     [20]byte // ERC-20 asset address (zero address means native ETH)
+	From      [20]byte
+	ChainID   int64
+	Signer    EthSigner
+	rpcIDNext uint64
+}
+
+// NewEth returns an Eth settlement backend for the escrow contract at
+// escrow, moving the ERC-20 token at token (or native ETH if token is the
+// zero address), signing transactions from address from with signer.
+func NewEth(rpcURL string, escrow, token, from [20]byte, chainID int64, signer EthSigner) *Eth {
+	return &Eth{RPCURL: rpcURL, Escrow: escrow, Token: token, From: from, ChainID: chainID, Signer: signer}
+}
+
+func (e *Eth) ProposePayment(ctx context.Context, buyer ed25519.PublicKey, amount int64, assetID []byte, clearRoot, cipherRoot [32]byte, revealDeadline, refundDeadline time.Time) (anchor, prog []byte, err error) {
+	data := abiPack(
+		selector("propose(bytes32,bytes32,uint256,address,uint256,uint256)"),
+		abiBytes32(clearRoot),
+		abiBytes32(cipherRoot),
+		abiUint256(big.NewInt(amount)),
+		abiAddress(e.Token),
+		abiUint256(big.NewInt(revealDeadline.Unix())),
+		abiUint256(big.NewInt(refundDeadline.Unix())),
+	)
+	prog, err = e.signAndSend(ctx, data)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "proposing payment")
+	}
+	// The escrow contract's address plus the tx hash of the proposal is
+	// a unique handle for this commitment; the seller's reveal log
+	// carries the same hash back as its anchor topic.
+	sum := keccak256(prog)
+	return sum[:], prog, nil
+}
+
+func (e *Eth) ParseAcceptance(event []byte, anchor []byte) *Acceptance {
+	var lg ethLog
+	if err := json.Unmarshal(event, &lg); err != nil {
+		return nil
+	}
+	if len(lg.Topics) != 2 || lg.Topics[0] != keyRevealedTopic {
+		return nil
+	}
+	if !bytes.Equal(mustDecodeHex(lg.Topics[1]), anchor) {
+		return nil
+	}
+	data := mustDecodeHex(lg.Data)
+	if len(data) < 96 {
+		return nil
+	}
+	var (
+		anchor2 [32]byte
+		seller  [20]byte
+		key     [32]byte
+	)
+	copy(anchor2[:], data[0:32])
+	copy(seller[:], data[44:64]) // address is right-aligned in its 32-byte word
+	copy(key[:], data[64:96])
+	// seller is a 20-byte Ethereum address, not an ed25519 public key;
+	// Acceptance.Seller is deliberately untyped ([]byte) so this doesn't
+	// have to pretend otherwise.
+	return &Acceptance{Anchor2: anchor2[:], Seller: seller[:], Key: key[:]}
+}
+
+func (e *Eth) ClaimRefund(ctx context.Context, r *Redeem, index int64, cipherChunk, clearHash []byte, cipherProof, clearProof merkle.Proof) error {
+	// The signature's proof-array parameters must be fixed-size
+	// (bytes32[N], bool[N]), matching what abiProofSiblings/abiProofSides
+	// actually encode: N consecutive words with no length/offset header.
+	// Writing bytes32[]/bool[] here would select on the dynamic-array
+	// signature while sending static-array calldata - a selector no real
+	// contract (fixed- or dynamic-array) would accept. cipherChunk, by
+	// contrast, genuinely is variable-length (it's a full tedd.ChunkSize
+	// chunk, not a 32-byte hash), so it's encoded as a real ABI dynamic
+	// bytes parameter - an offset word in the static head, with its
+	// length and contents in the tail - rather than truncated into a
+	// word the way bytesToHash would. The contract hashes cipherChunk
+	// itself (with the index prefix), the same division of labor as
+	// txvm's ClaimRefund.
+	//
+	// r.Anchor2 is included so the contract can tell which commitment
+	// this refund is against; without it, a contract handling concurrent
+	// escrows would have no way to find the right one.
+	sig := fmt.Sprintf(
+		"claimRefund(uint256,bytes32[%d],bool[%d],bytes32[%d],bool[%d],bytes32,bytes32,bytes)",
+		merkleMaxDepth, merkleMaxDepth, merkleMaxDepth, merkleMaxDepth,
+	)
+	// Static words before the dynamic cipherChunk's offset word: index,
+	// cipherProof siblings/sides, clearProof siblings/sides, clearHash,
+	// anchor2, then the offset word itself.
+	staticWords := int64(4 + 4*merkleMaxDepth)
+	data := abiPack(
+		selector(sig),
+		abiUint256(big.NewInt(index)),
+		abiProofSiblings(cipherProof),
+		abiProofSides(cipherProof),
+		abiProofSiblings(clearProof),
+		abiProofSides(clearProof),
+		abiBytes32(bytesToHash(clearHash)),
+		abiBytes32(r.Anchor2),
+		abiUint256(big.NewInt(staticWords*32)),
+		abiBytes(cipherChunk),
+	)
+	_, err := e.signAndSend(ctx, data)
+	return errors.Wrap(err, "claiming refund")
+}
+
+// Now returns the timestamp of the chain's latest block.
+func (e *Eth) Now() (time.Time, error) {
+	var blk struct {
+		Timestamp string `json:"timestamp"`
+	}
+	if err := e.call("eth_getBlockByNumber", []interface{}{"latest", false}, &blk); err != nil {
+		return time.Time{}, errors.Wrap(err, "getting latest block")
+	}
+	secs, err := parseHexUint(blk.Timestamp)
+	if err != nil {
+		return time.Time{}, errors.Wrap(err, "parsing block timestamp")
+	}
+	return time.Unix(int64(secs), 0), nil
+}
+
+// Watch polls eth_getLogs for KeyRevealed events on the escrow contract
+// whose first indexed topic is anchor, the EVM analogue of txvm's
+// transaction log: one poll per block interval rather than a push
+// subscription, since not every node offers eth_subscribe over HTTP.
+func (e *Eth) Watch(ctx context.Context, anchor []byte, cb func(event []byte)) error {
+	fromBlock := "earliest"
+	ticker := time.NewTicker(15 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			var logs []ethLog
+			filter := map[string]interface{}{
+				"address":   "0x" + hex.EncodeToString(e.Escrow[:]),
+				"topics":    []interface{}{keyRevealedTopic, "0x" + hex.EncodeToString(anchor)},
+				"fromBlock": fromBlock,
+				"toBlock":   "latest",
+			}
+			if err := e.call("eth_getLogs", []interface{}{filter}, &logs); err != nil {
+				return errors.Wrap(err, "polling for key-revealed logs")
+			}
+			for _, lg := range logs {
+				raw, err := json.Marshal(lg)
+				if err != nil {
+					return err
+				}
+				cb(raw)
+			}
+			fromBlock = "latest"
+		}
+	}
+}
+
+// keyRevealedTopic is the keccak256 hash of the escrow contract's
+// KeyRevealed(bytes32,bytes32,address,bytes32) event signature.
+var keyRevealedTopic = "0x" + hex.EncodeToString(selector32("KeyRevealed(bytes32,bytes32,address,bytes32)"))
+
+type ethLog struct {
+	Topics []string `json:"topics"`
+	Data   string   `json:"data"`
+}
+
+func (e *Eth) signAndSend(ctx context.Context, data []byte) ([]byte, error) {
+	var nonceHex string
+	if err := e.call("eth_getTransactionCount", []interface{}{"0x" + hex.EncodeToString(e.From[:]), "pending"}, &nonceHex); err != nil {
+		return nil, errors.Wrap(err, "getting nonce")
+	}
+	nonce, err := parseHexUint(nonceHex)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing nonce")
+	}
+
+	tx := ethTx{nonce: nonce, to: e.Escrow, data: data, chainID: e.ChainID}
+	hash := tx.signingHash()
+	sig, err := e.Signer(hash)
+	if err != nil {
+		return nil, errors.Wrap(err, "signing transaction")
+	}
+	raw := tx.encodeSigned(sig)
+
+	var txHash string
+	if err := e.call("eth_sendRawTransaction", []interface{}{"0x" + hex.EncodeToString(raw)}, &txHash); err != nil {
+		return nil, errors.Wrap(err, "sending transaction")
+	}
+	return raw, nil
+}
+
+func (e *Eth) call(method string, params []interface{}, result interface{}) error {
+	e.rpcIDNext++
+	reqBody, err := json.Marshal(map[string]interface{}{
+		"jsonrpc": "2.0",
+		"id":      e.rpcIDNext,
+		"method":  method,
+		"params":  params,
+	})
+	if err != nil {
+		return err
+	}
+	resp, err := http.Post(e.RPCURL, "application/json", bytes.NewReader(reqBody))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var rpcResp struct {
+		Result json.RawMessage `json:"result"`
+		Error  *struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&rpcResp); err != nil {
+		return err
+	}
+	if rpcResp.Error != nil {
+		return fmt.Errorf("%s: %s", method, rpcResp.Error.Message)
+	}
+	return json.Unmarshal(rpcResp.Result, result)
+}
+
+func parseHexUint(s string) (uint64, error) {
+	s = trimHexPrefix(s)
+	if s == "" {
+		return 0, nil
+	}
+	var v uint64
+	_, err := fmt.Sscanf(s, "%x", &v)
+	return v, err
+}
+
+func trimHexPrefix(s string) string {
+	if len(s) >= 2 && s[0] == '0' && (s[1] == 'x' || s[1] == 'X') {
+		return s[2:]
+	}
+	return s
+}
+
+func mustDecodeHex(s string) []byte {
+	b, _ := hex.DecodeString(trimHexPrefix(s))
+	return b
+}
+
+func bytesToHash(b []byte) [32]byte {
+	var h [32]byte
+	copy(h[:], b)
+	return h
+}
+
+func keccak256(data []byte) [32]byte {
+	h := sha3.NewLegacyKeccak256()
+	h.Write(data)
+	var sum [32]byte
+	copy(sum[:], h.Sum(nil))
+	return sum
+}
+
+func selector(sig string) []byte {
+	return selector32(sig)[:4]
+}
+
+func selector32(sig string) [32]byte {
+	return keccak256([]byte(sig))
+}
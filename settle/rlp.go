@@ -0,0 +1,106 @@
+package settle
+
+import "math/big"
+
+// ethTx is the minimal subset of a legacy (EIP-155) Ethereum transaction
+// Eth needs to call the escrow contract. Nothing here estimates gas: when
+// unset, gas price defaults to zero, which only works against a
+// permissioned/free-gas test chain, and gas limit defaults to 200000.
+type ethTx struct {
+	nonce    uint64
+	gasPrice *big.Int
+	gasLimit uint64
+	to       [20]byte
+	value    *big.Int
+	data     []byte
+	chainID  int64
+}
+
+func (tx *ethTx) fields() []interface{} {
+	gasPrice := tx.gasPrice
+	if gasPrice == nil {
+		gasPrice = new(big.Int)
+	}
+	value := tx.value
+	if value == nil {
+		value = new(big.Int)
+	}
+	gasLimit := tx.gasLimit
+	if gasLimit == 0 {
+		gasLimit = 200000
+	}
+	return []interface{}{tx.nonce, gasPrice, gasLimit, tx.to[:], value, tx.data}
+}
+
+// signingHash returns the EIP-155 hash this transaction's signature is
+// computed over.
+func (tx *ethTx) signingHash() [32]byte {
+	fields := append(tx.fields(), big.NewInt(tx.chainID), uint64(0), uint64(0))
+	return keccak256(rlpEncodeList(fields))
+}
+
+// encodeSigned returns the RLP encoding of this transaction with sig
+// (r||s||v, v as the ECDSA recovery id 0 or 1) attached as an EIP-155
+// signature.
+func (tx *ethTx) encodeSigned(sig [65]byte) []byte {
+	v := int64(sig[64]) + 35 + 2*tx.chainID
+	r := new(big.Int).SetBytes(sig[:32])
+	s := new(big.Int).SetBytes(sig[32:64])
+	fields := append(tx.fields(), big.NewInt(v), r, s)
+	return rlpEncodeList(fields)
+}
+
+// rlpEncodeList and rlpEncodeItem implement just enough of RLP
+// (Recursive Length Prefix, as specified for Ethereum) to serialize the
+// flat lists of uints/byte-strings that make up a legacy transaction.
+func rlpEncodeList(items []interface{}) []byte {
+	var body []byte
+	for _, it := range items {
+		body = append(body, rlpEncodeItem(it)...)
+	}
+	return append(rlpHeader(0xc0, len(body)), body...)
+}
+
+func rlpEncodeItem(it interface{}) []byte {
+	switch v := it.(type) {
+	case []byte:
+		return rlpEncodeBytes(v)
+	case uint64:
+		return rlpEncodeBytes(rlpTrimUint(v))
+	case *big.Int:
+		return rlpEncodeBytes(v.Bytes())
+	default:
+		panic("rlpEncodeItem: unsupported type")
+	}
+}
+
+func rlpEncodeBytes(b []byte) []byte {
+	if len(b) == 1 && b[0] < 0x80 {
+		return b
+	}
+	return append(rlpHeader(0x80, len(b)), b...)
+}
+
+func rlpHeader(base byte, n int) []byte {
+	if n < 56 {
+		return []byte{base + byte(n)}
+	}
+	var lenBytes []byte
+	for n > 0 {
+		lenBytes = append([]byte{byte(n & 0xff)}, lenBytes...)
+		n >>= 8
+	}
+	return append([]byte{base + 55 + byte(len(lenBytes))}, lenBytes...)
+}
+
+func rlpTrimUint(v uint64) []byte {
+	if v == 0 {
+		return nil
+	}
+	var b []byte
+	for v > 0 {
+		b = append([]byte{byte(v & 0xff)}, b...)
+		v >>= 8
+	}
+	return b
+}
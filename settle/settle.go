@@ -0,0 +1,79 @@
+// Package settle abstracts the payment/settlement backend a Tredd transfer
+// runs against, so that `get` and `serve` can be written once against an
+// interface instead of being hard-wired to github.com/bobg/tredd's txvm
+// contracts and a single bcurl HTTP endpoint. Any chain that can express a
+// hash-locked escrow (commit funds, reveal a key to release them, or refund
+// after a deadline) can back a transfer by implementing Settlement.
+package settle
+
+import (
+	"context"
+	"time"
+
+	"github.com/bobg/merkle"
+	"github.com/chain/txvm/crypto/ed25519"
+)
+
+// Acceptance is the chain-agnostic result of a seller's key reveal: enough
+// for a buyer to decrypt its content (or, if ClearRoot verification fails,
+// to claim a refund), independent of how the underlying chain represents
+// it on the wire.
+type Acceptance struct {
+	// Anchor2 is the anchor of the Value tuple holding payment plus
+	// collateral after the seller's reveal, needed to redeem the contract.
+	Anchor2 []byte
+
+	// Seller is the seller's identity in whatever form the backend's
+	// contract uses to check it: an ed25519 public key for Txvm, a
+	// 20-byte address for Eth. It's opaque here and only meaningful fed
+	// back into the same backend's Redeem.Seller; callers that need an
+	// actual ed25519.PublicKey must know they're talking to Txvm.
+	Seller []byte
+	Key    []byte
+}
+
+// Redeem holds the values needed to claim a refund from a settlement
+// backend, independent of how that backend represents an asset ID or an
+// amount on the wire.
+type Redeem struct {
+	RefundDeadline        time.Time
+	Buyer, Seller         ed25519.PublicKey
+	Amount                int64
+	AssetID               []byte
+	Anchor2               [32]byte
+	CipherRoot, ClearRoot [32]byte
+	Key                   [32]byte
+}
+
+// Settlement is the payment/settlement backend for a transfer. Everything
+// in `get`/`serve` that used to call github.com/bobg/tredd and chain/txvm
+// directly goes through a Settlement instead, so a transfer can run over
+// any chain that can express hash-locked escrow, not just txvm.
+type Settlement interface {
+	// ProposePayment builds (and, where the backend requires it, submits)
+	// the buyer's funds commitment, returning the anchor that later
+	// ParseAcceptance calls are matched against and the serialized
+	// proposal to hand to the seller.
+	ProposePayment(ctx context.Context, buyer ed25519.PublicKey, amount int64, assetID []byte, clearRoot, cipherRoot [32]byte, revealDeadline, refundDeadline time.Time) (anchor, prog []byte, err error)
+
+	// ParseAcceptance inspects a chain event (a txvm transaction's log, an
+	// Ethereum log entry, etc.) and, if it is the seller's key reveal for
+	// anchor, returns the Acceptance it contains. It returns nil if event
+	// isn't a match.
+	ParseAcceptance(event []byte, anchor []byte) *Acceptance
+
+	// ClaimRefund builds and submits a refund claim for the chunk at
+	// index, given the sibling proofs for its clear hash and cipher
+	// chunk. A negative index means the seller never revealed a key at
+	// all: the claim rests on refundDeadline alone, and cipherChunk,
+	// clearHash, cipherProof, and clearProof are ignored.
+	ClaimRefund(ctx context.Context, r *Redeem, index int64, cipherChunk, clearHash []byte, cipherProof, clearProof merkle.Proof) error
+
+	// Now returns the settlement backend's notion of the current time,
+	// used to compute reveal/refund deadlines.
+	Now() (time.Time, error)
+
+	// Watch invokes cb with every chain event observed after anchor's
+	// commitment, until ctx is canceled or an unrecoverable error occurs.
+	Watch(ctx context.Context, anchor []byte, cb func(event []byte)) error
+}
@@ -0,0 +1,154 @@
+package settle
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/bobg/merkle"
+	"github.com/bobg/tredd"
+	"github.com/chain/txvm/crypto/ed25519"
+	"github.com/chain/txvm/errors"
+	"github.com/chain/txvm/protocol/bc"
+	"github.com/chain/txvm/protocol/txvm"
+)
+
+// Txvm is the original Settlement backend: it builds and redeems the
+// github.com/bobg/tredd contracts and submits them to a chain/txvm
+// blockchain server reachable at BcURL, exactly as `get` did before
+// Settlement existed.
+type Txvm struct {
+	Reserver         tredd.Reserver
+	ReserveOpts      *tredd.ReserveOpts
+	Signer           tredd.Signer
+	ConcurrentSigner tredd.ConcurrentSigner
+	BcURL            string
+}
+
+// NewTxvm returns a Txvm settlement backend. opts may be nil to preserve
+// Reserver's pre-existing selection behavior.
+func NewTxvm(reserver tredd.Reserver, opts *tredd.ReserveOpts, signer tredd.Signer, concurrentSigner tredd.ConcurrentSigner, bcURL string) *Txvm {
+	return &Txvm{Reserver: reserver, ReserveOpts: opts, Signer: signer, ConcurrentSigner: concurrentSigner, BcURL: bcURL}
+}
+
+func (t *Txvm) ProposePayment(ctx context.Context, buyer ed25519.PublicKey, amount int64, assetID []byte, clearRoot, cipherRoot [32]byte, revealDeadline, refundDeadline time.Time) (anchor, prog []byte, err error) {
+	now, err := t.Now()
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "getting current time")
+	}
+	prog, err = tredd.ProposePayment(ctx, buyer, amount, bc.HashFromBytes(assetID), clearRoot, cipherRoot, now, revealDeadline, refundDeadline, t.Reserver, t.ReserveOpts, t.Signer, t.ConcurrentSigner)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "proposing payment")
+	}
+	parsed := tredd.ParseLog(prog)
+	if parsed == nil {
+		return nil, nil, errors.New("cannot parse log of proposed payment transaction")
+	}
+	return parsed.Anchor1, prog, nil
+}
+
+func (t *Txvm) ParseAcceptance(event []byte, anchor []byte) *Acceptance {
+	parsed := tredd.ParseLog(event)
+	if parsed == nil || !bytes.Equal(parsed.Anchor1, anchor) || parsed.Key == nil {
+		return nil
+	}
+	return &Acceptance{Anchor2: parsed.Anchor2, Seller: parsed.Seller, Key: parsed.Key}
+}
+
+func (t *Txvm) ClaimRefund(ctx context.Context, r *Redeem, index int64, cipherChunk, clearHash []byte, cipherProof, clearProof merkle.Proof) error {
+	redeem := &tredd.Redeem{
+		RefundDeadline: r.RefundDeadline,
+		Buyer:          r.Buyer,
+		Seller:         r.Seller,
+		Amount:         r.Amount,
+		AssetID:        bc.HashFromBytes(r.AssetID),
+		Anchor2:        r.Anchor2,
+		CipherRoot:     r.CipherRoot,
+		ClearRoot:      r.ClearRoot,
+		Key:            r.Key,
+	}
+	prog, err := tredd.ClaimRefund(redeem, index, cipherChunk, clearHash, cipherProof, clearProof)
+	if err != nil {
+		return errors.Wrap(err, "building refund claim")
+	}
+	vm, err := txvm.Validate(prog, 3, math.MaxInt64)
+	if err != nil {
+		return errors.Wrap(err, "validating refund claim")
+	}
+	return t.submit(ctx, prog, math.MaxInt64-vm.Runlimit())
+}
+
+// Now returns this process's local wall-clock time. It does not query the
+// blockchain server or its observer for the time.
+func (t *Txvm) Now() (time.Time, error) {
+	return time.Now(), nil
+}
+
+// submit posts prog to the blockchain server's /submit endpoint, the same
+// endpoint and request shape `get`'s submitter used.
+func (t *Txvm) submit(ctx context.Context, prog []byte, runlimit int64) error {
+	req, err := http.NewRequest("POST", t.BcURL+"/submit", bytes.NewReader(prog))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("X-Tredd-Runlimit", strconv.FormatInt(runlimit, 10))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "submitting transaction")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return errors.Wrapf(errors.New(resp.Status), "submitting transaction")
+	}
+	return nil
+}
+
+// Watch polls the blockchain server's /get endpoint for transactions
+// touching anchor. The server's actual subscription wire format isn't
+// fixed by this package; this assumes a simple "poll, 204 when nothing
+// new" shape consistent with the rest of Txvm's HTTP use and should be
+// adjusted to match the real blockchain server's protocol.
+func (t *Txvm) Watch(ctx context.Context, anchor []byte, cb func(event []byte)) error {
+	vals := url.Values{}
+	vals.Set("anchor", string(anchor))
+	getURL := t.BcURL + "/get?" + vals.Encode()
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			req, err := http.NewRequest("GET", getURL, nil)
+			if err != nil {
+				return err
+			}
+			req = req.WithContext(ctx)
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				return errors.Wrap(err, "polling for settlement events")
+			}
+			if resp.StatusCode == http.StatusNoContent {
+				resp.Body.Close()
+				continue
+			}
+			body, err := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			if err != nil {
+				return errors.Wrap(err, "reading settlement event")
+			}
+			if resp.StatusCode/100 != 2 {
+				return errors.Wrapf(errors.New(resp.Status), "polling for settlement events")
+			}
+			cb(body)
+		}
+	}
+}
@@ -0,0 +1,297 @@
+package tredd
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"io"
+
+	"github.com/bobg/merkle"
+	"github.com/chain/txvm/errors"
+)
+
+// ChunkVerifier decrypts a single cipher chunk for GetAndDecrypt. Its
+// caller (not GetAndDecrypt) knows the decryption scheme and the key;
+// GetAndDecrypt only needs to know whether a chunk's plaintext is the one
+// committed to by its claimed clear hash.
+type ChunkVerifier interface {
+	// Decrypt decrypts the index-th cipher chunk of the stream.
+	Decrypt(index uint64, cipherChunk []byte) (clear []byte, err error)
+}
+
+// StreamBadClearHashError reports that the chunk at Index decrypted to
+// plaintext that doesn't hash to the clear hash the stream claimed for
+// it. It plays the same role for GetAndDecrypt that tedd.BadClearHashError
+// plays for the on-disk Get-then-Decrypt path.
+type StreamBadClearHashError struct {
+	Index uint64
+}
+
+func (e StreamBadClearHashError) Error() string {
+	return fmt.Sprintf("bad clear hash at chunk %d", e.Index)
+}
+
+// RefundNeeded is returned by GetAndDecrypt when a chunk fails
+// verification, carrying everything ClaimRefund needs for it so the
+// caller doesn't have to re-read the stream.
+type RefundNeeded struct {
+	Index       uint64
+	ClearHash   []byte
+	CipherChunk []byte
+	Cause       error
+}
+
+func (e *RefundNeeded) Error() string {
+	return fmt.Sprintf("chunk %d failed verification: %s", e.Index, e.Cause)
+}
+
+func (e *RefundNeeded) Unwrap() error { return e.Cause }
+
+// ErrRefundOutOfWindow is returned by GetAndDecrypt in place of
+// RefundNeeded when the chunk that failed verification has already fallen
+// out of the ring buffer (only possible with a verifier that checks
+// chunks out of order, e.g. a pipelined/concurrent one; the synchronous
+// path below always fails on the chunk it's currently holding). The
+// caller should fall back to the on-disk Get-then-Decrypt path, which can
+// recover the chunk's bytes by replaying the stream from the start.
+var ErrRefundOutOfWindow = errors.New("chunk needing refund is outside the streaming cache window; fall back to on-disk mode")
+
+// ringEntry is one chunk GetAndDecrypt is still holding onto, in case it
+// turns out to need a refund.
+type ringEntry struct {
+	index       uint64
+	clearHash   []byte
+	cipherChunk []byte
+}
+
+// GetAndDecrypt reads (clearHash, cipherChunk) records from r (see
+// readRecord for the wire format), verifying and decrypting each chunk
+// with verifier as it arrives and writing its plaintext straight to out.
+// Where it applies, this makes only one pass over the content instead of
+// the Get-then-Decrypt pair's two: clear hashes and cipher chunks are
+// each folded into a running Merkle root as they're seen (see
+// peakAccumulator) instead of being written to disk and re-read; only
+// the most recent cacheSize chunks are held in memory, in a ring buffer,
+// against the chance that one of them turns out to need a refund.
+//
+// Building a refund proof for a chunk still needs its position among
+// every leaf, so GetAndDecrypt also appends every clear and cipher leaf
+// hash (32 bytes each, regardless of chunk size) to ProofCache files at
+// clearProofPath and cipherProofPath as it goes; ProofFromCache can later
+// derive a proof for any index from those, not just ones still in the
+// ring buffer.
+//
+// If the clear-hash stream doesn't fold up to clearRoot, GetAndDecrypt
+// returns a plain error: that means the seller sent hashes for a
+// different file than the one requested, which isn't something
+// ClaimRefund (a proof that one specific chunk's plaintext is
+// inconsistent with its own claimed hash) is built to remedy.
+//
+// GetAndDecrypt requires a ChunkVerifier that already holds the
+// decryption key when the stream is read. Tredd's buyer protocol doesn't
+// offer that: a buyer must verify the cipher stream against clearRoot
+// before proposing payment, and the seller's key is only revealed after
+// payment is accepted - by which point the cipher stream has already
+// been fully received once. `cmd/tedd` (see client.go, finish.go)
+// therefore still goes through github.com/bobg/tedd's Get/Decrypt pair,
+// which pays for that ordering with a second pass; GetAndDecrypt is for
+// a caller whose transport reveals the key up front.
+func GetAndDecrypt(r io.Reader, clearRoot [32]byte, out io.Writer, verifier ChunkVerifier, cacheSize int, clearProofPath, cipherProofPath string) (cipherRoot []byte, err error) {
+	clearCache, err := NewProofCache(clearProofPath, sha256.New)
+	if err != nil {
+		return nil, errors.Wrap(err, "creating clear-hash proof cache")
+	}
+	defer clearCache.Close()
+
+	cipherCache, err := NewProofCache(cipherProofPath, sha256.New)
+	if err != nil {
+		return nil, errors.Wrap(err, "creating cipher-chunk proof cache")
+	}
+	defer cipherCache.Close()
+
+	var (
+		clearAcc  = newPeakAccumulator(sha256.New)
+		cipherAcc = newPeakAccumulator(sha256.New)
+		ring      []ringEntry
+		index     uint64
+	)
+
+	for {
+		clearHash, cipherChunk, rerr := readRecord(r)
+		if rerr == io.EOF {
+			break
+		}
+		if rerr != nil {
+			return nil, errors.Wrap(rerr, "reading stream")
+		}
+
+		if err := clearCache.Add(clearHash); err != nil {
+			return nil, errors.Wrap(err, "caching clear hash")
+		}
+		if err := cipherCache.Add(cipherChunk); err != nil {
+			return nil, errors.Wrap(err, "caching cipher chunk")
+		}
+		clearAcc.add(leafHash(sha256.New, index, clearHash))
+		cipherAcc.add(leafHash(sha256.New, index, cipherChunk))
+
+		clear, verr := verifier.Decrypt(index, cipherChunk)
+		if verr == nil && !bytes.Equal(sum256(clear), clearHash) {
+			verr = StreamBadClearHashError{Index: index}
+		}
+		if verr != nil {
+			return nil, refundNeeded(index, clearHash, cipherChunk, ring, cacheSize, verr)
+		}
+
+		if _, err := out.Write(clear); err != nil {
+			return nil, errors.Wrap(err, "writing plaintext")
+		}
+
+		ring = append(ring, ringEntry{index: index, clearHash: clearHash, cipherChunk: cipherChunk})
+		if len(ring) > cacheSize {
+			ring = ring[1:]
+		}
+
+		index++
+	}
+
+	if !bytes.Equal(clearAcc.root(), clearRoot[:]) {
+		return nil, errors.New("clear-hash stream does not match the requested clear root")
+	}
+	return cipherAcc.root(), nil
+}
+
+// refundNeeded decides whether the chunk at index (which just failed
+// verification with cause) is still available, either because it's the
+// one readRecord just returned or because it's still in ring, and builds
+// the corresponding error.
+func refundNeeded(index uint64, clearHash, cipherChunk []byte, ring []ringEntry, cacheSize int, cause error) error {
+	for _, e := range ring {
+		if e.index == index {
+			return &RefundNeeded{Index: index, ClearHash: e.clearHash, CipherChunk: e.cipherChunk, Cause: cause}
+		}
+	}
+	// ring[0] is the oldest entry still cached; an index older than that
+	// has already been evicted. An index newer than everything in ring
+	// (the synchronous path's only case: the chunk readRecord just
+	// returned, which hasn't been added to ring yet) falls through to the
+	// direct case below instead.
+	if cacheSize > 0 && len(ring) > 0 && index < ring[0].index {
+		return ErrRefundOutOfWindow
+	}
+	return &RefundNeeded{Index: index, ClearHash: clearHash, CipherChunk: cipherChunk, Cause: cause}
+}
+
+func sum256(b []byte) []byte {
+	sum := sha256.Sum256(b)
+	return sum[:]
+}
+
+// leafHash hashes data the same way ProofCache.Add does, so that the
+// peakAccumulator's running root lines up with ProofFromCache's proofs.
+func leafHash(hasher func() hash.Hash, index uint64, data []byte) []byte {
+	var prefix [binary.MaxVarintLen64]byte
+	m := binary.PutUvarint(prefix[:], index)
+	h := hasher()
+	merkle.LeafHash(h, prefix[:m], data)
+	return h.Sum(nil)
+}
+
+// peakAccumulator computes an RFC 6962 MTH root incrementally, one leaf
+// hash at a time, without needing to know the final leaf count in
+// advance: it keeps one "peak" hash per power-of-two-sized complete
+// subtree seen so far (peaks[i] is a 2^i-leaf subtree), combining two
+// peaks of the same size into the next size up as they complete, the same
+// carry propagation as incrementing a binary counter. The final root
+// folds these peaks from the smallest up, each larger peak becoming the
+// left child of everything smaller than it, which is exactly RFC 6962's
+// recursive, largest-power-of-two-first split.
+type peakAccumulator struct {
+	hasher func() hash.Hash
+	peaks  [][]byte
+}
+
+func newPeakAccumulator(hasher func() hash.Hash) *peakAccumulator {
+	return &peakAccumulator{hasher: hasher}
+}
+
+func (p *peakAccumulator) add(h []byte) {
+	for i := 0; i < len(p.peaks); i++ {
+		if p.peaks[i] == nil {
+			p.peaks[i] = h
+			return
+		}
+		h = combine(p.hasher(), p.peaks[i], h)
+		p.peaks[i] = nil
+	}
+	p.peaks = append(p.peaks, h)
+}
+
+func (p *peakAccumulator) root() []byte {
+	var acc []byte
+	for i := 0; i < len(p.peaks); i++ {
+		if p.peaks[i] == nil {
+			continue
+		}
+		if acc == nil {
+			acc = p.peaks[i]
+			continue
+		}
+		acc = combine(p.hasher(), p.peaks[i], acc)
+	}
+	return acc
+}
+
+// readRecord reads one (clearHash, cipherChunk) record from r: a
+// varint-prefixed clear hash followed by a varint-prefixed cipher chunk.
+// This is GetAndDecrypt's own wire format; it doesn't attempt to match
+// tedd.Get's, which isn't visible from this package.
+func readRecord(r io.Reader) (clearHash, cipherChunk []byte, err error) {
+	clearHash, err = readLenPrefixed(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	cipherChunk, err = readLenPrefixed(r)
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, nil, err
+	}
+	return clearHash, cipherChunk, nil
+}
+
+func readLenPrefixed(r io.Reader) ([]byte, error) {
+	n, err := binary.ReadUvarint(byteReaderOf(r))
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// byteReaderOf adapts r to io.ByteReader for binary.ReadUvarint, since r
+// (the network connection or HTTP response body GetAndDecrypt is handed)
+// isn't guaranteed to implement it already.
+func byteReaderOf(r io.Reader) io.ByteReader {
+	if br, ok := r.(io.ByteReader); ok {
+		return br
+	}
+	return &singleByteReader{r: r}
+}
+
+type singleByteReader struct {
+	r   io.Reader
+	buf [1]byte
+}
+
+func (s *singleByteReader) ReadByte() (byte, error) {
+	if _, err := io.ReadFull(s.r, s.buf[:]); err != nil {
+		return 0, err
+	}
+	return s.buf[0], nil
+}
@@ -0,0 +1,129 @@
+package tredd
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+// identityVerifier treats cipher chunks as already-decrypted plaintext, so
+// tests can exercise GetAndDecrypt's verification and ring-buffer logic
+// without needing a real cipher.
+type identityVerifier struct {
+	badIndex uint64 // chunk at this index decrypts to the wrong plaintext
+	hasBad   bool
+}
+
+func (v identityVerifier) Decrypt(index uint64, cipherChunk []byte) ([]byte, error) {
+	if v.hasBad && index == v.badIndex {
+		return []byte("wrong plaintext"), nil
+	}
+	return cipherChunk, nil
+}
+
+func writeRecord(buf *bytes.Buffer, clearHash, cipherChunk []byte) {
+	var prefix [binary.MaxVarintLen64]byte
+	m := binary.PutUvarint(prefix[:], uint64(len(clearHash)))
+	buf.Write(prefix[:m])
+	buf.Write(clearHash)
+	m = binary.PutUvarint(prefix[:], uint64(len(cipherChunk)))
+	buf.Write(prefix[:m])
+	buf.Write(cipherChunk)
+}
+
+func buildStream(t *testing.T, n int) (stream *bytes.Buffer, clearRoot [32]byte, chunks [][]byte) {
+	t.Helper()
+
+	clearAcc := newPeakAccumulator(sha256.New)
+	stream = new(bytes.Buffer)
+	for i := 0; i < n; i++ {
+		chunk := bytes.Repeat([]byte{byte(i)}, 8)
+		chunks = append(chunks, chunk)
+		clearHash := sum256(chunk)
+		clearAcc.add(leafHash(sha256.New, uint64(i), clearHash))
+		writeRecord(stream, clearHash, chunk)
+	}
+	copy(clearRoot[:], clearAcc.root())
+	return stream, clearRoot, chunks
+}
+
+func TestGetAndDecryptHappyPath(t *testing.T) {
+	stream, clearRoot, chunks := buildStream(t, 5)
+
+	var out bytes.Buffer
+	clearProof := t.TempDir() + "/clear"
+	cipherProof := t.TempDir() + "/cipher"
+
+	cipherRoot, err := GetAndDecrypt(stream, clearRoot, &out, identityVerifier{}, 2, clearProof, cipherProof)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cipherRoot == nil {
+		t.Fatal("got nil cipher root")
+	}
+	if got := out.Bytes(); !bytes.Equal(got, bytes.Join(chunks, nil)) {
+		t.Errorf("decrypted output = %x, want %x", got, bytes.Join(chunks, nil))
+	}
+}
+
+func TestGetAndDecryptBadChunk(t *testing.T) {
+	stream, clearRoot, _ := buildStream(t, 5)
+
+	var out bytes.Buffer
+	clearProof := t.TempDir() + "/clear"
+	cipherProof := t.TempDir() + "/cipher"
+
+	verifier := identityVerifier{badIndex: 4, hasBad: true}
+	_, err := GetAndDecrypt(stream, clearRoot, &out, verifier, 3, clearProof, cipherProof)
+
+	var refundErr *RefundNeeded
+	if !errors.As(err, &refundErr) {
+		t.Fatalf("got error %v, want *RefundNeeded", err)
+	}
+	if refundErr.Index != 4 {
+		t.Errorf("RefundNeeded.Index = %d, want 4", refundErr.Index)
+	}
+}
+
+// GetAndDecrypt's own verifier runs synchronously right after each chunk
+// is read, so it can only ever fail on the chunk it's currently holding
+// (see refundNeeded's doc comment) - ErrRefundOutOfWindow is reachable
+// only through a verifier that checks chunks out of order, which
+// refundNeeded is exercised against directly here.
+func TestRefundNeededOutOfWindow(t *testing.T) {
+	ring := []ringEntry{{index: 3, clearHash: []byte("h3"), cipherChunk: []byte("c3")}}
+
+	err := refundNeeded(1, []byte("h1"), []byte("c1"), ring, 1, errors.New("bad"))
+	if !errors.Is(err, ErrRefundOutOfWindow) {
+		t.Fatalf("got error %v, want ErrRefundOutOfWindow", err)
+	}
+}
+
+func TestRefundNeededStillInRing(t *testing.T) {
+	ring := []ringEntry{{index: 3, clearHash: []byte("h3"), cipherChunk: []byte("c3")}}
+
+	err := refundNeeded(3, []byte("stale"), []byte("stale"), ring, 1, errors.New("bad"))
+	var refundErr *RefundNeeded
+	if !errors.As(err, &refundErr) {
+		t.Fatalf("got error %v, want *RefundNeeded", err)
+	}
+	if string(refundErr.ClearHash) != "h3" || string(refundErr.CipherChunk) != "c3" {
+		t.Errorf("RefundNeeded carried %q/%q, want the ring's own entry, not the stale args", refundErr.ClearHash, refundErr.CipherChunk)
+	}
+}
+
+func TestGetAndDecryptWrongClearRoot(t *testing.T) {
+	stream, _, _ := buildStream(t, 3)
+
+	var out bytes.Buffer
+	clearProof := t.TempDir() + "/clear"
+	cipherProof := t.TempDir() + "/cipher"
+
+	var wrongRoot [32]byte
+	_, err := GetAndDecrypt(stream, wrongRoot, &out, identityVerifier{}, 2, clearProof, cipherProof)
+	if err == nil {
+		t.Fatal("expected an error for a mismatched clear root")
+	}
+}
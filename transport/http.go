@@ -0,0 +1,114 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/chain/txvm/crypto/ed25519"
+	"github.com/chain/txvm/protocol/bc"
+)
+
+// HTTP is the original transport: a POST to addr+"/request" returns the
+// cipher stream in its response body and the transfer ID in a header, and
+// a POST to addr+"/propose-payment" carries the payment program, both
+// correlated by the X-Tedd-Transfer-Id header.
+type HTTP struct {
+	client http.Client
+
+	mu     sync.Mutex
+	addrs  map[string]string        // transferID -> base addr, for SendPayment
+	bodies map[string]io.ReadCloser // transferID -> cipher stream, for StreamCipher
+}
+
+// NewHTTP returns an HTTP transport.
+func NewHTTP() *HTTP {
+	return &HTTP{
+		addrs:  make(map[string]string),
+		bodies: make(map[string]io.ReadCloser),
+	}
+}
+
+func (h *HTTP) Request(ctx context.Context, addr string, buyer ed25519.PublicKey, clearRoot [32]byte, amount int64, assetID []byte, revealDeadline, refundDeadline time.Time, rng Range) (string, error) {
+	vals := make(url.Values)
+	vals.Add("clearroot", hex.EncodeToString(clearRoot[:]))
+	vals.Add("amount", strconv.FormatInt(amount, 10))
+	vals.Add("assetid", hex.EncodeToString(assetID))
+	vals.Add("revealdeadline", strconv.FormatInt(int64(bc.Millis(revealDeadline)), 10)) // xxx range check
+	vals.Add("refunddeadline", strconv.FormatInt(int64(bc.Millis(refundDeadline)), 10)) // xxx range check
+	if rng != (Range{}) {
+		vals.Add("rangelo", strconv.FormatUint(rng.Lo, 10))
+		vals.Add("rangehi", strconv.FormatUint(rng.Hi, 10))
+	}
+
+	req, err := http.NewRequest("POST", addr+"/request", bytes.NewReader([]byte(vals.Encode())))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req = req.WithContext(ctx)
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("requesting content: %w", err)
+	}
+	if resp.StatusCode/100 != 2 {
+		resp.Body.Close()
+		return "", fmt.Errorf("status code %d from initial HTTP request", resp.StatusCode)
+	}
+
+	transferID := resp.Header.Get("X-Tedd-Transfer-Id")
+
+	h.mu.Lock()
+	h.addrs[transferID] = addr
+	h.bodies[transferID] = resp.Body
+	h.mu.Unlock()
+
+	return transferID, nil
+}
+
+func (h *HTTP) StreamCipher(ctx context.Context, transferID string) (io.ReadCloser, error) {
+	h.mu.Lock()
+	body, ok := h.bodies[transferID]
+	delete(h.bodies, transferID)
+	h.mu.Unlock()
+
+	if !ok {
+		return nil, fmt.Errorf("no pending cipher stream for transfer %s", transferID)
+	}
+	return body, nil
+}
+
+func (h *HTTP) SendPayment(ctx context.Context, transferID string, prog []byte) error {
+	h.mu.Lock()
+	addr, ok := h.addrs[transferID]
+	h.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("no known address for transfer %s", transferID)
+	}
+
+	req, err := http.NewRequest("POST", addr+"/propose-payment", bytes.NewReader(prog))
+	if err != nil {
+		return fmt.Errorf("constructing payment proposal: %w", err)
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("X-Tedd-Transfer-Id", transferID)
+
+	resp, err := h.client.Do(req) // from this point, funds are committed - perhaps even in case of error
+	if err != nil {
+		return fmt.Errorf("sending payment proposal: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("sending payment proposal: unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
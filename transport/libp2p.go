@@ -0,0 +1,219 @@
+package transport
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/chain/txvm/crypto/ed25519"
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p-core/host"
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/protocol"
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/multiformats/go-multiaddr"
+)
+
+// cipherStreamProtocol is the libp2p protocol ID a seller listens on for
+// direct cipher-chunk streams, opened once a content request has been
+// accepted over gossipsub.
+const cipherStreamProtocol = "/tredd/cipher/1.0.0"
+
+// gossipsubMsg is the envelope for both message kinds published on a
+// content topic: a buyer's "content-request" and a seller's
+// "content-accepted" reply. Keeping both on one topic (per the request
+// that prompted this transport, scoped by clearRoot) means a seller only
+// has to watch one topic per piece of content it's willing to sell.
+type gossipsubMsg struct {
+	Type           string `json:"type"`
+	ClearRoot      string `json:"clear_root"`
+	Amount         int64  `json:"amount,omitempty"`
+	AssetID        string `json:"asset_id,omitempty"`
+	RevealDeadline int64  `json:"reveal_deadline_ms,omitempty"`
+	RefundDeadline int64  `json:"refund_deadline_ms,omitempty"`
+	Buyer          string `json:"buyer,omitempty"` // hex-encoded ed25519 public key
+	Peer           string `json:"peer,omitempty"`  // libp2p peer ID of the message's sender
+	TransferID     string `json:"transfer_id,omitempty"`
+	RangeLo        uint64 `json:"range_lo,omitempty"`
+	RangeHi        uint64 `json:"range_hi,omitempty"`
+}
+
+// Libp2p is a Transport that announces content requests over gossipsub,
+// topic-scoped by clearRoot, and moves the cipher-chunk bulk transfer
+// over a direct libp2p stream instead of a second pubsub round-trip.
+// Payment proposals (small, and needing a reliable 1:1 delivery rather
+// than a broadcast) also go over a direct stream.
+type Libp2p struct {
+	host host.Host
+	ps   *pubsub.PubSub
+
+	mu     sync.Mutex
+	topics map[string]*pubsub.Topic // clearRoot hex -> topic
+	peers  map[string]peer.ID       // transferID -> seller's peer ID
+}
+
+// NewLibp2p starts a libp2p host and joins gossipsub on it. The host
+// listens on an OS-assigned port on all interfaces; a real deployment
+// would want to pass through listen-address and identity configuration
+// instead of taking libp2p's defaults.
+func NewLibp2p(ctx context.Context) (*Libp2p, error) {
+	h, err := libp2p.New()
+	if err != nil {
+		return nil, fmt.Errorf("starting libp2p host: %w", err)
+	}
+	ps, err := pubsub.NewGossipSub(ctx, h)
+	if err != nil {
+		return nil, fmt.Errorf("starting gossipsub: %w", err)
+	}
+	return &Libp2p{
+		host:   h,
+		ps:     ps,
+		topics: make(map[string]*pubsub.Topic),
+		peers:  make(map[string]peer.ID),
+	}, nil
+}
+
+// topicName scopes a content-request/content-accepted topic by
+// clearRootHex, so a seller only has to subscribe to content it actually
+// has.
+func topicName(clearRootHex string) string {
+	return "tredd/request/" + clearRootHex
+}
+
+func (l *Libp2p) topic(clearRootHex string) (*pubsub.Topic, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if t, ok := l.topics[clearRootHex]; ok {
+		return t, nil
+	}
+	t, err := l.ps.Join(topicName(clearRootHex))
+	if err != nil {
+		return nil, err
+	}
+	l.topics[clearRootHex] = t
+	return t, nil
+}
+
+func (l *Libp2p) Request(ctx context.Context, addr string, buyer ed25519.PublicKey, clearRoot [32]byte, amount int64, assetID []byte, revealDeadline, refundDeadline time.Time, rng Range) (string, error) {
+	if err := l.connect(ctx, addr); err != nil {
+		return "", fmt.Errorf("connecting to seller: %w", err)
+	}
+
+	clearRootHex := hex.EncodeToString(clearRoot[:])
+	t, err := l.topic(clearRootHex)
+	if err != nil {
+		return "", fmt.Errorf("joining request topic: %w", err)
+	}
+
+	sub, err := t.Subscribe()
+	if err != nil {
+		return "", fmt.Errorf("subscribing to request topic: %w", err)
+	}
+	defer sub.Cancel()
+
+	msg := gossipsubMsg{
+		Type:           "content-request",
+		ClearRoot:      clearRootHex,
+		Amount:         amount,
+		AssetID:        hex.EncodeToString(assetID),
+		RevealDeadline: revealDeadline.UnixNano() / int64(time.Millisecond),
+		RefundDeadline: refundDeadline.UnixNano() / int64(time.Millisecond),
+		Buyer:          hex.EncodeToString(buyer),
+		Peer:           l.host.ID().String(),
+		RangeLo:        rng.Lo,
+		RangeHi:        rng.Hi,
+	}
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return "", err
+	}
+	if err := t.Publish(ctx, data); err != nil {
+		return "", fmt.Errorf("publishing content request: %w", err)
+	}
+
+	// Wait for whichever seller is first to accept; this transport makes
+	// no attempt to pick among multiple offers (see chunk1-7 for a
+	// multi-seller strategy).
+	for {
+		pmsg, err := sub.Next(ctx)
+		if err != nil {
+			return "", fmt.Errorf("awaiting content acceptance: %w", err)
+		}
+		var reply gossipsubMsg
+		if err := json.Unmarshal(pmsg.Data, &reply); err != nil {
+			continue
+		}
+		if reply.Type != "content-accepted" || reply.ClearRoot != clearRootHex {
+			continue
+		}
+		sellerID, err := peer.Decode(reply.Peer)
+		if err != nil {
+			continue
+		}
+
+		l.mu.Lock()
+		l.peers[reply.TransferID] = sellerID
+		l.mu.Unlock()
+
+		return reply.TransferID, nil
+	}
+}
+
+func (l *Libp2p) SendPayment(ctx context.Context, transferID string, prog []byte) error {
+	s, err := l.openStream(ctx, transferID, cipherStreamProtocol+"/payment")
+	if err != nil {
+		return fmt.Errorf("opening payment stream: %w", err)
+	}
+	defer s.Close()
+
+	if _, err := s.Write(prog); err != nil {
+		return fmt.Errorf("sending payment proposal: %w", err)
+	}
+	return nil
+}
+
+func (l *Libp2p) StreamCipher(ctx context.Context, transferID string) (io.ReadCloser, error) {
+	s, err := l.openStream(ctx, transferID, cipherStreamProtocol)
+	if err != nil {
+		return nil, fmt.Errorf("opening cipher stream: %w", err)
+	}
+	return bufReader(s), nil
+}
+
+func (l *Libp2p) openStream(ctx context.Context, transferID, proto string) (network.Stream, error) {
+	l.mu.Lock()
+	sellerID, ok := l.peers[transferID]
+	l.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("no known seller for transfer %s", transferID)
+	}
+	return l.host.NewStream(ctx, sellerID, protocol.ID(proto))
+}
+
+func (l *Libp2p) connect(ctx context.Context, addr string) error {
+	maddr, err := multiaddr.NewMultiaddr(addr)
+	if err != nil {
+		return err
+	}
+	info, err := peer.AddrInfoFromP2pAddr(maddr)
+	if err != nil {
+		return err
+	}
+	return l.host.Connect(ctx, *info)
+}
+
+// bufReader wraps a network.Stream so callers (tedd.Get, in particular)
+// get buffered reads instead of one read syscall per small record.
+func bufReader(s network.Stream) io.ReadCloser {
+	return struct {
+		io.Reader
+		io.Closer
+	}{bufio.NewReader(s), s}
+}
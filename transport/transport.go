@@ -0,0 +1,68 @@
+// Package transport abstracts the buyer/seller rendezvous tredd's `get`
+// command drives: announcing a content request, delivering the signed
+// payment proposal once it's been built, and receiving the seller's
+// cipher-chunk stream. The original protocol bolted all three directly
+// onto plain HTTP; this package lets `get` pick a different carrier (see
+// Libp2p) by the scheme of the address it's given, without its own logic
+// needing to know which one is in play.
+//
+// This would live at github.com/bobg/tedd/transport in the original
+// tree, but that package isn't part of this snapshot; it's rooted here
+// alongside this repo's other additions (settle, bridge) instead.
+package transport
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/chain/txvm/crypto/ed25519"
+)
+
+// Range is a half-open chunk-index range [Lo, Hi) a buyer wants from a
+// seller. The zero Range (Lo == 0 && Hi == 0) asks for the whole file,
+// which is what every caller but `get-multi` wants.
+type Range struct {
+	Lo, Hi uint64
+}
+
+// Transport carries the three buyer/seller interactions `get` needs,
+// independent of what moves the bytes.
+type Transport interface {
+	// Request announces a content request for clearRoot to addr, for the
+	// chunks in rng (or the whole file, if rng is the zero Range), and
+	// returns the transfer ID the seller assigns it. addr's scheme (see
+	// Pick) decides which Transport implementation handles it.
+	Request(ctx context.Context, addr string, buyer ed25519.PublicKey, clearRoot [32]byte, amount int64, assetID []byte, revealDeadline, refundDeadline time.Time, rng Range) (transferID string, err error)
+
+	// SendPayment delivers the buyer's signed payment-proposal program for
+	// transferID (previously returned by Request) to whichever seller
+	// accepted it.
+	SendPayment(ctx context.Context, transferID string, prog []byte) error
+
+	// StreamCipher returns the cipher-chunk stream for transferID, in the
+	// wire format tedd.Get expects. The caller is responsible for closing
+	// it.
+	StreamCipher(ctx context.Context, transferID string) (io.ReadCloser, error)
+}
+
+// Pick returns the Transport that handles addr: an HTTP base URL
+// ("http://" or "https://") gets the existing HTTP transport; a libp2p
+// multiaddr (starting with "/") gets the gossipsub-plus-stream transport.
+func Pick(ctx context.Context, addr string) (Transport, error) {
+	if strings.HasPrefix(addr, "/") {
+		return NewLibp2p(ctx)
+	}
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, fmt.Errorf("parsing transport address %q: %w", addr, err)
+	}
+	switch u.Scheme {
+	case "http", "https":
+		return NewHTTP(), nil
+	}
+	return nil, fmt.Errorf("unsupported transport address %q", addr)
+}
@@ -32,9 +32,11 @@ func ProposePayment(
 	clearRoot, cipherRoot [32]byte,
 	now, revealDeadline, refundDeadline time.Time,
 	reserver Reserver,
+	opts *ReserveOpts,
 	signer Signer,
+	concurrentSigner ConcurrentSigner,
 ) ([]byte, error) {
-	reservation, err := reserver.Reserve(ctx, amount, assetID, now, revealDeadline)
+	reservation, err := reserver.Reserve(ctx, amount, assetID, now, revealDeadline, opts)
 	if err != nil {
 		return nil, errors.Wrap(err, "reserving utxos")
 	}
@@ -57,6 +59,10 @@ func ProposePayment(
 		return nil, errors.Wrap(err, "querying change amount from reservation")
 	}
 	if change > 0 {
+		// A reserver honoring opts.Strategy (e.g. Changeless) may already have
+		// picked utxos summing to exactly amount, in which case Change returns
+		// 0 and this whole branch is skipped; it still has to be here for
+		// strategies (including the default) that don't guarantee that.
 		treddLogPos += 3 // one 'O' and two 'L' log entries
 		fmt.Fprintf(buf, "%d peeklog untuple\n", treddLogPos-1)
 
@@ -124,8 +130,21 @@ func ProposePayment(
 		return nil, errors.Wrap(err, "assembling signature program")
 	}
 
-	anchoredSigprog := make([]byte, 32+len(sigprog))
-	copy(anchoredSigprog, sigprog)
+	// Splice each utxo's anchor onto the shared sigprog tail, computed once,
+	// then sign all of them (possibly concurrently, if concurrentSigner says
+	// it's safe) before assembling the spend program, which must happen
+	// serially since txvmutil.Builder is not thread-safe.
+	anchoredMsgs := make([][]byte, len(utxos))
+	for i, utxo := range utxos {
+		msg := make([]byte, len(sigprog)+32)
+		copy(msg, sigprog)
+		copy(msg[len(sigprog):], utxo.Anchor())
+		anchoredMsgs[i] = msg
+	}
+	sigs, err := signConcurrently(ctx, signer, concurrentSigner, anchoredMsgs)
+	if err != nil {
+		return nil, errors.Wrap(err, "signing inputs")
+	}
 
 	b := new(txvmutil.Builder)
 	for i, utxo := range utxos {
@@ -134,12 +153,7 @@ func ProposePayment(
 		// arg stack: [<value> <deferred contract>]
 		b.Op(op.Get) // contract stack: [<deferred contract>] arg stack: [<value>]
 
-		copy(anchoredSigprog[len(sigprog):], utxo.Anchor()) // this is what to sign
-		sig, err := signer(anchoredSigprog)
-		if err != nil {
-			return nil, errors.Wrap(err, "signing input")
-		}
-		b.PushdataBytes(sig).Op(op.Put)
+		b.PushdataBytes(sigs[i]).Op(op.Put)
 		b.PushdataBytes(sigprog).Op(op.Put)
 		b.Op(op.Call) // arg stack is again [<value> <deferred contract>]
 
@@ -197,9 +211,12 @@ func RevealKey(
 	amount int64,
 	assetID bc.Hash,
 	reserver Reserver,
+	opts *ReserveOpts,
 	signer Signer,
+	concurrentSigner ConcurrentSigner,
 	wantClearRoot, wantCipherRoot [32]byte,
 	now, wantRevealDeadline, wantRefundDeadline time.Time,
+	cachedTxID *bc.Hash,
 ) ([]byte, error) {
 	parsed := ParseLog(paymentProposal)
 	if parsed == nil {
@@ -224,7 +241,7 @@ func RevealKey(
 		return nil, fmt.Errorf("got asset ID %x, want %x", parsed.AssetID, assetID.Bytes())
 	}
 
-	reservation, err := reserver.Reserve(ctx, amount, assetID, now, wantRevealDeadline)
+	reservation, err := reserver.Reserve(ctx, amount, assetID, now, wantRevealDeadline, opts)
 	if err != nil {
 		return nil, errors.Wrap(err, "reserving utxos")
 	}
@@ -284,22 +301,36 @@ func RevealKey(
 	}
 	tx1 = append(paymentProposal, tx1...)
 
-	vm, err := txvm.Validate(tx1, 3, math.MaxInt64, txvm.StopAfterFinalize)
+	txID := cachedTxID
+	if txID == nil {
+		// No precomputed TxID was supplied (e.g. from a cached dry-run
+		// txvm.Validate across retries where only signatures changed), so
+		// compute it here, as RevealKey has always done.
+		vm, err := txvm.Validate(tx1, 3, math.MaxInt64, txvm.StopAfterFinalize)
+		if err != nil {
+			return nil, errors.Wrap(err, "computing transaction ID")
+		}
+		txID = &vm.TxID
+	}
+
+	// sign seller utxos. The inputs are addressed in reverse order below
+	// (utxos[len(utxos)-1] first), so build the message list in that same
+	// order before handing it to signConcurrently, which preserves order.
+	sigprog := standard.VerifyTxID(*txID)
+	anchoredMsgs := make([][]byte, len(utxos))
+	for i := len(utxos) - 1; i >= 0; i-- {
+		msg := append([]byte{}, sigprog...)
+		msg = append(msg, utxos[i].Anchor()...)
+		anchoredMsgs[len(utxos)-1-i] = msg
+	}
+	sigs, err := signConcurrently(ctx, signer, concurrentSigner, anchoredMsgs)
 	if err != nil {
-		return nil, errors.Wrap(err, "computing transaction ID")
+		return nil, errors.Wrap(err, "computing signatures")
 	}
 
-	// sign seller utxos
 	buf = new(bytes.Buffer)
-	sigprog := standard.VerifyTxID(vm.TxID)
 	for i := len(utxos) - 1; i >= 0; i-- {
-		utxo := utxos[i]
-		anchoredSigprog := append([]byte{}, sigprog...)
-		anchoredSigprog = append(anchoredSigprog, utxo.Anchor()...)
-		sig, err := signer(anchoredSigprog)
-		if err != nil {
-			return nil, errors.Wrap(err, "computing signature")
-		}
+		sig := sigs[len(utxos)-1-i]
 		fmt.Fprintf(buf, "get x'%x' put x'%x' put call\n", sig, sigprog)
 	}
 	tx2, err := asm.Assemble(buf.String())
@@ -356,15 +387,25 @@ func ClaimPayment(r *Redeem) ([]byte, error) {
 
 // ClaimRefund constructs a buyer-claims-refund transaction,
 // rehydrating a Tredd contract from the utxo state (identified by the information in r)
-// and calling it with the necessary proofs and other information.
+// and calling it with the necessary proofs and other information. A
+// negative index means the seller never revealed a key at all: there is
+// no specific chunk to prove wrong, so no per-chunk proof is built
+// (cipherChunk, clearHash, cipherProof, and clearProof are ignored), and
+// the claim rests on refundDeadline alone.
 func ClaimRefund(r *Redeem, index int64, cipherChunk []byte, clearHash []byte, cipherProof, clearProof merkle.Proof) ([]byte, error) {
+	buf := redeem(r)
+	if index < 0 {
+		fmt.Fprintln(buf, "1 put call")
+		fmt.Fprintln(buf, "get finalize")
+		return asm.Assemble(buf.String())
+	}
+
 	var prefix [binary.MaxVarintLen64]byte
 	m := binary.PutUvarint(prefix[:], uint64(index))
 
-	buf := redeem(r)
-	renderProof(buf, cipherProof)
+	RenderProof(buf, cipherProof)
 	fmt.Fprintln(buf, "put")
-	renderProof(buf, clearProof)
+	RenderProof(buf, clearProof)
 	fmt.Fprintln(buf, "put")
 	fmt.Fprintf(buf, "x'%x' put\n", clearHash)
 	fmt.Fprintf(buf, "x'%x' put\n", cipherChunk)
@@ -374,7 +415,12 @@ func ClaimRefund(r *Redeem, index int64, cipherChunk []byte, clearHash []byte, c
 	return asm.Assemble(buf.String())
 }
 
-func renderProof(w io.Writer, proof merkle.Proof) {
+// RenderProof writes proof as a txvm asm tuple literal in reverse step
+// order, proof's last (root-side) step first, for embedding in a
+// ClaimRefund-style assembled program. Exported so other packages building
+// their own variant of the Tredd contract (see bridge.ClaimRefund) can
+// reuse it instead of re-deriving the same asm shape.
+func RenderProof(w io.Writer, proof merkle.Proof) {
 	fmt.Fprint(w, "{")
 	for i := len(proof) - 1; i >= 0; i-- {
 		if i < len(proof)-1 {
@@ -1,4 +1,4 @@
-package tedd
+package tredd
 
 import (
 	"context"
@@ -61,4 +61,4 @@ func (u *testUTXO) AssetID() bc.Hash {
 
 func (u *testUTXO) Anchor() []byte {
 	return u.anchor
-}
\ No newline at end of file
+}